@@ -0,0 +1,277 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+
+	defaultBreakerFailures   = 3
+	defaultBreakerMaxBackoff = 5 * time.Minute
+
+	// breakerMinBackoff is the first open period after consecutive
+	// failures, doubled each time the breaker opens again
+	breakerMinBackoff = 10 * time.Second
+)
+
+var breakerStates = []string{breakerClosed, breakerOpen, breakerHalfOpen}
+
+// CollectorOption configures optional behaviour of the KibanaCollector.
+type CollectorOption func(*KibanaCollector)
+
+// WithCircuitBreaker sets the number of consecutive failed status requests
+// that open the circuit breaker, 0 disables it, and the maximum time it
+// stays open. Defaults to 3 failures and 5m.
+func WithCircuitBreaker(failures int, maxBackoff time.Duration) CollectorOption {
+	return func(c *KibanaCollector) {
+		c.breaker = newCircuitBreaker(failures, maxBackoff)
+	}
+}
+
+// parseRetryAfter returns the delay in the Retry-After header, in either
+// the seconds or the HTTP date format, 0 if there is none.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// circuitBreaker stops the status requests to Kibana for a while after
+// consecutive failures, or when Kibana asks for it with Retry-After, and
+// serves the last good status in the meantime. Once the open period is
+// over, a single request is let through to check whether Kibana has
+// recovered.
+type circuitBreaker struct {
+	lock sync.Mutex
+
+	// failures is the number of consecutive failures that open the
+	// breaker, 0 if disabled
+	failures   int
+	maxBackoff time.Duration
+
+	state     string
+	openUntil time.Time
+
+	// checkUntil is when the half open check is given up on, so that a
+	// check that never completes does not keep the breaker half open
+	checkUntil time.Time
+
+	// consecutive counts the failed requests since the last success, and
+	// opens the number of times the breaker opened since then
+	consecutive int
+	opens       int
+
+	// last is the last good status, served while the breaker is open
+	last     *KibanaMetrics
+	lastTime time.Time
+}
+
+func newCircuitBreaker(failures int, maxBackoff time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failures:   failures,
+		maxBackoff: maxBackoff,
+		state:      breakerClosed,
+	}
+}
+
+// allow returns whether a status request can be made. An open breaker
+// moves to half open once the open period is over, letting a single
+// request through. Another request is let through if that one has not
+// completed within the maximum backoff.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Before(b.openUntil) {
+			return false
+		}
+
+		log.Info().
+			Msg("circuit breaker is half open, checking whether kibana has recovered")
+		b.state = breakerHalfOpen
+		b.checkUntil = now.Add(b.maxBackoff)
+		return true
+	case breakerHalfOpen:
+		// the check is in flight
+		if now.Before(b.checkUntil) {
+			return false
+		}
+
+		log.Warn().
+			Msgf("circuit breaker check did not complete within %s, checking again", b.maxBackoff)
+		b.checkUntil = now.Add(b.maxBackoff)
+		return true
+	default:
+		return true
+	}
+}
+
+// open will stop the requests for the given period, capped at the maximum
+// backoff. Must be called with the lock held.
+func (b *circuitBreaker) open(period time.Duration, now time.Time, reason string) {
+	if period > b.maxBackoff {
+		period = b.maxBackoff
+	}
+
+	b.state = breakerOpen
+	b.opens++
+	b.openUntil = now.Add(period)
+
+	log.Warn().
+		Msgf("circuit breaker opened for %s, %s", period, reason)
+}
+
+// record will update the breaker with the outcome of a status request.
+func (b *circuitBreaker) record(m *KibanaMetrics, code int, retryAfter time.Duration, err error, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		b.last = m
+		b.lastTime = now
+	}
+
+	if b.failures <= 0 {
+		// disabled
+		return
+	}
+
+	// Kibana asked to slow down, even if it responded with a status
+	overloaded := code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+	if overloaded && retryAfter > 0 {
+		b.consecutive++
+		b.open(retryAfter, now, fmt.Sprintf("kibana responded with %d and Retry-After %s", code, retryAfter))
+		return
+	}
+
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Info().
+				Msg("circuit breaker closed, kibana has recovered")
+		}
+
+		b.state = breakerClosed
+		b.consecutive = 0
+		b.opens = 0
+		return
+	}
+
+	b.consecutive++
+	if b.state == breakerHalfOpen || b.consecutive >= b.failures {
+		backoff := breakerMinBackoff
+		for i := 0; i < b.opens && backoff < b.maxBackoff; i++ {
+			backoff *= 2
+		}
+
+		b.open(backoff, now, fmt.Sprintf("after %d consecutive failures: %s", b.consecutive, err))
+	}
+}
+
+// stale returns a copy of the last good status marked as stale, or an
+// error if there is none.
+func (b *circuitBreaker) stale(now time.Time) (*KibanaMetrics, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	retry := b.openUntil.Sub(now).Round(time.Second)
+	if b.last == nil {
+		return nil, fmt.Errorf("circuit breaker is %s, not requesting Kibana status for %s", b.state, retry)
+	}
+
+	m := *b.last
+	m.Stale = true
+
+	log.Debug().
+		Msgf("circuit breaker is %s, using the Kibana status from %s", b.state, b.lastTime)
+
+	return &m, nil
+}
+
+// lastGood returns the last good status, nil if there is none.
+func (b *circuitBreaker) lastGood() *KibanaMetrics {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.last
+}
+
+// currentState returns the state of the breaker.
+func (b *circuitBreaker) currentState() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+// breakerMetrics builds the metrics for the circuit breaker of the status
+// requests.
+type breakerMetrics struct {
+	state *prometheus.Desc
+	stale *prometheus.Desc
+}
+
+func newBreakerMetrics(namespace string, constLabels prometheus.Labels) *breakerMetrics {
+	return &breakerMetrics{
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "circuit_breaker_state"),
+			"State of the circuit breaker of the Kibana status requests, 1 for the current state and 0 for the others",
+			[]string{"state"},
+			constLabels),
+		stale: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "status_stale"),
+			"Whether the metrics of the last scrape are from an earlier Kibana status, served while the circuit breaker is open",
+			nil,
+			constLabels),
+	}
+}
+
+func (b *breakerMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- b.state
+	ch <- b.stale
+}
+
+func (b *breakerMetrics) collect(ch chan<- prometheus.Metric, state string, stale bool) {
+	for _, s := range breakerStates {
+		val := 0.0
+		if s == state {
+			val = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(b.state, prometheus.GaugeValue, val, s)
+	}
+
+	val := 0.0
+	if stale {
+		val = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(b.stale, prometheus.GaugeValue, val)
+}
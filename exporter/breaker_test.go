@@ -0,0 +1,171 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC)
+	values := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		" 5 ":                           5 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Tue, 02 May 2023 10:01:30 GMT": 90 * time.Second,
+		"Tue, 02 May 2023 09:59:00 GMT": 0,
+	}
+
+	for value, expected := range values {
+		header := http.Header{}
+		if value != "" {
+			header.Set("Retry-After", value)
+		}
+
+		if got := parseRetryAfter(header, now); got != expected {
+			t.Errorf("expected %s for Retry-After %q, got %s", expected, value, got)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(3, time.Minute)
+	failure := errors.New("connection refused")
+
+	if _, err := b.stale(now); err == nil {
+		t.Errorf("expected an error without a good status")
+	}
+
+	b.record(&KibanaMetrics{Name: "kibana"}, http.StatusOK, 0, nil, now)
+
+	for i := 0; i < 2; i++ {
+		b.record(nil, 0, 0, failure, now)
+		if !b.allow(now) {
+			t.Fatalf("expected the breaker to stay closed after %d failures", i+1)
+		}
+	}
+
+	b.record(nil, 0, 0, failure, now)
+	if b.currentState() != breakerOpen || b.allow(now.Add(breakerMinBackoff-time.Second)) {
+		t.Fatalf("expected the breaker to open after 3 failures")
+	}
+
+	m, err := b.stale(now)
+	if err != nil || !m.Stale || m.Name != "kibana" {
+		t.Errorf("expected the last good status marked as stale, got %+v, %v", m, err)
+	}
+
+	// a single check once the open period is over
+	now = now.Add(breakerMinBackoff)
+	if !b.allow(now) || b.allow(now) || b.currentState() != breakerHalfOpen {
+		t.Fatalf("expected a single request to be let through when half open")
+	}
+
+	// a check that never completes is given up on after the maximum backoff
+	if b.allow(now.Add(time.Minute-time.Second)) || !b.allow(now.Add(time.Minute)) || b.allow(now.Add(time.Minute)) {
+		t.Fatalf("expected another single request to be let through after the maximum backoff")
+	}
+
+	// a failed check opens the breaker for twice as long
+	b.record(nil, 0, 0, failure, now)
+	if b.allow(now.Add(2*breakerMinBackoff-time.Second)) || !b.allow(now.Add(2*breakerMinBackoff)) {
+		t.Errorf("expected the open period to double")
+	}
+
+	b.record(&KibanaMetrics{}, http.StatusOK, 0, nil, now)
+	if b.currentState() != breakerClosed || !b.allow(now) {
+		t.Errorf("expected the breaker to close after a successful check")
+	}
+
+	// the open period is capped
+	b.record(nil, http.StatusTooManyRequests, time.Hour, failure, now)
+	if b.currentState() != breakerOpen || b.allow(now.Add(time.Minute-time.Second)) || !b.allow(now.Add(time.Minute)) {
+		t.Errorf("expected Retry-After to open the breaker up to the maximum backoff")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		b.record(nil, http.StatusTooManyRequests, time.Minute, errors.New("too many requests"), now)
+	}
+
+	if !b.allow(now) {
+		t.Errorf("expected a disabled breaker to let all requests through")
+	}
+}
+
+func TestScrapeRetryAfter(t *testing.T) {
+	var overloaded atomic.Bool
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if overloaded.Load() {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		fmt.Fprint(w, `{"name":"kibana","status":{"overall":{"level":"available"}}}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewExporter("kibana", collector, WithCollectors([]string{"status"}))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatalf("unexpected gather error: %s", err)
+	}
+
+	overloaded.Store(true)
+	if _, err := collector.scrape(context.Background()); err == nil {
+		t.Fatalf("expected an error for a 429 response")
+	}
+
+	before := requests.Load()
+	expected := `
+# HELP kibana_exporter_circuit_breaker_state State of the circuit breaker of the Kibana status requests, 1 for the current state and 0 for the others
+# TYPE kibana_exporter_circuit_breaker_state gauge
+kibana_exporter_circuit_breaker_state{state="closed"} 0
+kibana_exporter_circuit_breaker_state{state="half_open"} 0
+kibana_exporter_circuit_breaker_state{state="open"} 1
+# HELP kibana_exporter_status_stale Whether the metrics of the last scrape are from an earlier Kibana status, served while the circuit breaker is open
+# TYPE kibana_exporter_status_stale gauge
+kibana_exporter_status_stale 1
+# HELP kibana_up Whether Kibana responded to the status request of the last scrape
+# TYPE kibana_up gauge
+kibana_up 0
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"kibana_exporter_circuit_breaker_state", "kibana_exporter_status_stale", "kibana_up")
+	if err != nil {
+		t.Errorf("unexpected circuit breaker output: %s", err)
+	}
+
+	if requests.Load() != before {
+		t.Errorf("expected no requests to Kibana while the breaker is open")
+	}
+}
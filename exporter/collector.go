@@ -1,18 +1,25 @@
 package exporter
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// defaultRequestTimeout is the maximum time a request to Kibana can take,
+// including reading the response body
+const defaultRequestTimeout = 10 * time.Second
+
 // KibanaCollector collects the Kibana information together to be used by
 // the exporter to scrape metrics.
 type KibanaCollector struct {
@@ -27,29 +34,56 @@ type KibanaCollector struct {
 	// client is the http.Client that will be used to make
 	// requests to collect the Kibana metrics
 	client *http.Client
+
+	// timeout is the maximum time a request to Kibana can take, 0 for no
+	// limit
+	timeout time.Duration
+
+	// tls is the certificate chain presented by Kibana on the last
+	// request, guarded by tlsLock
+	tlsLock sync.Mutex
+	tls     *tlsObservation
+
+	// breaker stops the status requests while Kibana is failing or asks
+	// to slow down
+	breaker *circuitBreaker
+
+	// health is the outcome of the requests by Kibana API path, used to
+	// report readiness
+	health *healthTracker
 }
 
 // KibanaMetrics is used to unmarshal the metrics response from Kibana.
 type KibanaMetrics struct {
+	// ResponseCode is the HTTP response code of the status response, not
+	// part of the response body
+	ResponseCode int `json:"-"`
+
+	// Stale is true if this is an earlier status, served while the
+	// circuit breaker is open
+	Stale bool `json:"-"`
+
+	// raw is the status response body, used to detect changes in the
+	// response schema
+	raw []byte
+
 	Name string `json:"name"`
+	UUID string `json:"uuid"`
 
 	Version struct {
 		Number string `json:"number"`
 	} `json:"version"`
 
 	Status struct {
-		Overall struct {
-			Level string `json:"level"`
-		} `json:"overall"`
+		Overall ServiceStatus `json:"overall"`
 
 		Core struct {
-			Elasticsearch struct {
-				Level string `json:"level"`
-			} `json:"elasticsearch"`
-			SavedObjects struct {
-				Level string `json:"level"`
-			} `json:"savedObjects"`
+			Elasticsearch ServiceStatus `json:"elasticsearch"`
+			SavedObjects  ServiceStatus `json:"savedObjects"`
 		} `json:"core"`
+
+		// Plugins is the status of each plugin by the plugin name
+		Plugins map[string]ServiceStatus `json:"plugins"`
 	} `json:"status"`
 
 	Metrics struct {
@@ -92,45 +126,67 @@ type KibanaMetrics struct {
 	} `json:"metrics"`
 }
 
+// ServiceStatus is the status of a single Kibana service or plugin.
+type ServiceStatus struct {
+	Level   string `json:"level"`
+	Summary string `json:"summary"`
+}
+
 // TestConnection checks whether the connection to Kibana is healthy
-func (c *KibanaCollector) TestConnection() bool {
+func (c *KibanaCollector) TestConnection(ctx context.Context) bool {
 	log.Debug().
 		Msg("checking for kibana status")
 
-	m, err := c.scrape()
+	m, err := c.scrape(ctx)
 	if err != nil {
 		log.Info().
 			Msgf("test connection to kibana failed: %s", err)
 		return false
 	}
 
+	if m.Stale {
+		log.Info().
+			Msg("test connection to kibana skipped while the circuit breaker is open")
+		return false
+	}
+
 	log.Info().
 		Msgf("connected to Kibana node %s with version %s and status %s", m.Name, m.Version.Number, m.Status.Overall.Level)
 
 	return true
 }
 
-// WaitForConnection is a method to block until Kibana becomes available
-func (c *KibanaCollector) WaitForConnection() {
-	for {
-		if !c.TestConnection() {
-			log.Info().
-				Msg("waiting for kibana to be responsive")
+// lastStatus returns the last status Kibana responded with, nil if it has
+// not responded yet.
+func (c *KibanaCollector) lastStatus() *KibanaMetrics {
+	if c.breaker == nil {
+		return nil
+	}
 
-			// hardcoded since it's unlikely this is user controlled
-			time.Sleep(10 * time.Second)
-			continue
-		}
+	return c.breaker.lastGood()
+}
 
-		log.Info().
-			Msg("kibana is up")
-		return
+// WithRequestTimeout sets the maximum time a request to Kibana can take,
+// so that a stalled connection does not hold up the scrapes waiting for
+// the collection. 0 disables the limit. Defaults to 10s.
+func WithRequestTimeout(timeout time.Duration) CollectorOption {
+	return func(c *KibanaCollector) {
+		c.timeout = timeout
 	}
 }
 
 // NewCollector builds a KibanaCollector struct
-func NewCollector(kibanaURI, kibanaUsername, kibanaPassword string, kibanaSkipTLS bool) (*KibanaCollector, error) {
-	collector := &KibanaCollector{}
+func NewCollector(kibanaURI, kibanaUsername, kibanaPassword string, kibanaSkipTLS bool, opts ...CollectorOption) (*KibanaCollector, error) {
+	collector := &KibanaCollector{
+		timeout: defaultRequestTimeout,
+		breaker: newCircuitBreaker(defaultBreakerFailures, defaultBreakerMaxBackoff),
+		health:  newHealthTracker(),
+	}
+
+	for _, opt := range opts {
+		opt(collector)
+	}
+
 	collector.url = kibanaURI
 
 	if strings.HasPrefix(kibanaURI, "https://") {
@@ -153,12 +209,15 @@ func NewCollector(kibanaURI, kibanaUsername, kibanaPassword string, kibanaSkipTL
 
 		collector.client = &http.Client{
 			Transport: tr,
+			Timeout:   collector.timeout,
 		}
 	} else {
 		log.Debug().
 			Msgf("kibana URL is a plain text one: %s", kibanaURI)
 
-		collector.client = &http.Client{}
+		collector.client = &http.Client{
+			Timeout: collector.timeout,
+		}
 		if kibanaSkipTLS {
 			log.Info().
 				Msgf("kibana.skip-tls is enabled for an http URL, ignoring: %s", kibanaURI)
@@ -180,16 +239,27 @@ func NewCollector(kibanaURI, kibanaUsername, kibanaPassword string, kibanaSkipTL
 	return collector, nil
 }
 
-// scrape will connect to the Kibana instance, using the details
-// provided by the KibanaCollector struct, and return the metrics as a
-// KibanaMetrics representation.
-func (c *KibanaCollector) scrape() (*KibanaMetrics, error) {
+// hostname returns the host part of the Kibana URL, without the port.
+func (c *KibanaCollector) hostname() string {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// newRequest will build an HTTP request against the given Kibana API path,
+// with the headers Kibana expects, including the Authorization header if
+// credentials were provided. The body can be nil for requests that do not
+// send any content. The request is cancelled with the context.
+func (c *KibanaCollector) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
 	log.Debug().
-		Msg("building request for api/status from kibana")
+		Msgf("building request for %s from kibana", path)
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/status", c.url), nil)
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s", c.url, path), body)
 	if err != nil {
-		return nil, fmt.Errorf("could not initialize a request to scrape metrics: %s", err)
+		return nil, fmt.Errorf("could not initialize a request to %s: %s", path, err)
 	}
 
 	if c.authHeader != "" {
@@ -199,14 +269,41 @@ func (c *KibanaCollector) scrape() (*KibanaMetrics, error) {
 	}
 
 	req.Header.Add("Accept", "application/json")
+	// internal APIs in Kibana 8.x reject requests without this header
+	req.Header.Add("x-elastic-internal-origin", "Kibana")
+
+	if method != http.MethodGet {
+		// Kibana rejects state changing requests without this header
+		req.Header.Add("kbn-xsrf", "true")
+	}
+
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// do will issue an HTTP request against the given Kibana API path, using
+// the details provided by the KibanaCollector struct, and return the
+// response code, the response headers, and the response body, whatever the
+// response code is.
+func (c *KibanaCollector) do(ctx context.Context, method, path string, body io.Reader) (int, http.Header, []byte, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
 
 	log.Debug().
-		Msg("requesting api/status from kibana")
+		Msgf("requesting %s from kibana", path)
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error while reading Kibana status: %s", err)
+		c.observeTLSError(err)
+		return 0, nil, nil, fmt.Errorf("error while requesting %s: %s", path, err)
 	}
 
+	c.observeTLS(resp.TLS)
+
 	// CWE-703
 	defer func() {
 		if err = resp.Body.Close(); err != nil {
@@ -215,22 +312,77 @@ func (c *KibanaCollector) scrape() (*KibanaMetrics, error) {
 	}()
 
 	log.Debug().
-		Msg("processing api/status response")
+		Msgf("processing %s response", path)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid response from Kibana status: %s", resp.Status)
+	respContent, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, resp.Header, nil, fmt.Errorf("error while reading response from Kibana for %s: %s", path, err)
 	}
 
-	respContent, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, respContent, nil
+}
+
+// request will issue an HTTP request against the given Kibana API path
+// and return the response body if Kibana responded with a 200.
+func (c *KibanaCollector) request(ctx context.Context, method, path string, body io.Reader) ([]byte, error) {
+	code, _, respContent, err := c.do(ctx, method, path, body)
+	if err == nil && code != http.StatusOK {
+		err = fmt.Errorf("invalid response from Kibana for %s: %d %s", path, code, http.StatusText(code))
+	}
+
+	c.health.record(path, code, err, time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("error while reading response from Kibana status: %s", err)
+		return nil, err
+	}
+
+	return respContent, nil
+}
+
+// scrape will connect to the Kibana instance, using the details
+// provided by the KibanaCollector struct, and return the metrics as a
+// KibanaMetrics representation. Kibana responds with a 503 and the full
+// status when the overall status is unavailable or critical, so the
+// response is used whatever the response code is, as long as it is a
+// valid status. The outcome is recorded for the readiness checks. While
+// the circuit breaker is open, the last good status is returned marked as
+// stale, without requesting Kibana.
+func (c *KibanaCollector) scrape(ctx context.Context) (*KibanaMetrics, error) {
+	if !c.breaker.allow(time.Now()) {
+		return c.breaker.stale(time.Now())
+	}
+
+	code, header, metrics, err := c.readStatus(ctx)
+	now := time.Now()
+	c.health.record(statusPath, code, err, now)
+	c.breaker.record(metrics, code, parseRetryAfter(header, now), err, now)
+
+	return metrics, err
+}
+
+// readStatus will request the Kibana status, and return the response code
+// and the headers along with the parsed status.
+func (c *KibanaCollector) readStatus(ctx context.Context) (int, http.Header, *KibanaMetrics, error) {
+	code, header, respContent, err := c.do(ctx, http.MethodGet, statusPath, nil)
+	if err != nil {
+		return code, header, nil, fmt.Errorf("error while reading Kibana status: %s", err)
 	}
 
 	metrics := &KibanaMetrics{}
 	err = json.Unmarshal(respContent, &metrics)
+	if code != http.StatusOK && (err != nil || metrics.Status.Overall.Level == "") {
+		return code, header, nil, fmt.Errorf("invalid response from Kibana status: %d %s", code, http.StatusText(code))
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("error while unmarshalling Kibana status: %s\nProblematic content:\n%s", err, respContent)
+		return code, header, nil, fmt.Errorf("error while unmarshalling Kibana status: %s\nProblematic content:\n%s", err, respContent)
+	}
+
+	if code != http.StatusOK {
+		log.Debug().
+			Msgf("using Kibana status from a %d response", code)
 	}
 
-	return metrics, nil
+	metrics.ResponseCode = code
+	metrics.raw = respContent
+	return code, header, metrics, nil
 }
@@ -1,7 +1,12 @@
 package exporter
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // auth header tests
@@ -100,3 +105,124 @@ func TestNewCollectorAuthHeader(t *testing.T) {
 		})
 	}
 }
+
+var scrapeTests = []struct {
+	desc, body string
+	code       int
+	valid      bool
+}{
+	{
+		desc:  "available",
+		code:  http.StatusOK,
+		body:  `{"name":"kibana","status":{"overall":{"level":"available"}}}`,
+		valid: true,
+	},
+	{
+		desc:  "unavailable with status body",
+		code:  http.StatusServiceUnavailable,
+		body:  `{"name":"kibana","status":{"overall":{"level":"critical"}}}`,
+		valid: true,
+	},
+	{
+		desc:  "unavailable from a proxy",
+		code:  http.StatusServiceUnavailable,
+		body:  `<html><body>Service Unavailable</body></html>`,
+		valid: false,
+	},
+	{
+		desc:  "unauthorized",
+		code:  http.StatusUnauthorized,
+		body:  `{"statusCode":401,"error":"Unauthorized","message":"Unauthorized"}`,
+		valid: false,
+	},
+}
+
+func TestScrapeResponseCodes(t *testing.T) {
+	for _, st := range scrapeTests {
+		t.Run(st.desc, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(st.code)
+				fmt.Fprint(w, st.body)
+			}))
+			defer ts.Close()
+
+			collector, err := NewCollector(ts.URL, "", "", false)
+			if err != nil {
+				t.Fatalf("NewCollector failed with valid input")
+			}
+
+			m, err := collector.scrape(context.Background())
+			if !st.valid {
+				if err == nil {
+					t.Errorf("expected error for an invalid status response")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error for a valid status response: %s", err)
+			}
+
+			if m.ResponseCode != st.code {
+				t.Errorf("expected response code %d, got %d", st.code, m.ResponseCode)
+			}
+		})
+	}
+}
+
+func TestRequestCancelled(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a stalled Kibana
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := collector.request(ctx, http.MethodGet, statusPath, nil); err == nil {
+		t.Errorf("expected an error for a cancelled request")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the request to stop when cancelled, took %s", elapsed)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a stalled Kibana
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	collector, err := NewCollector(ts.URL, "", "", false, WithRequestTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	start := time.Now()
+	if _, err := collector.request(context.Background(), http.MethodGet, statusPath, nil); err == nil {
+		t.Errorf("expected an error for a request that timed out")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the request to stop after the timeout, took %s", elapsed)
+	}
+}
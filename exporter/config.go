@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is used to unmarshal the exporter configuration file provided
+// with the -config.file flag.
+type Config struct {
+	// Metrics configures the naming and labelling of all the metrics
+	Metrics MetricsConfig `yaml:"metrics"`
+
+	// CustomMetrics lists the Kibana API endpoints to be converted to
+	// metrics using JSON path expressions
+	CustomMetrics []CustomMetricsEndpoint `yaml:"custom_metrics"`
+
+	// Probes lists the Kibana endpoints to measure the client side
+	// latency of
+	Probes ProbesConfig `yaml:"probes"`
+
+	// Push configures pushing the metrics to a Pushgateway
+	Push PushConfig `yaml:"push"`
+
+	// RemoteWrite configures sending the metrics to a Prometheus remote
+	// write endpoint
+	RemoteWrite RemoteWriteConfig `yaml:"remote_write"`
+
+	// OTLP configures exporting the metrics to an OpenTelemetry collector
+	OTLP OTLPConfig `yaml:"otlp"`
+}
+
+// LoadConfig reads and parses the exporter configuration file.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading config file %s: %s", path, err)
+	}
+
+	config := &Config{}
+	err = yaml.UnmarshalStrict(content, config)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing config file %s: %s", path, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadSampleConfig(t *testing.T) {
+	config, err := LoadConfig("../etc/kibana-exporter.yml")
+	if err != nil {
+		t.Fatalf("unexpected error while loading sample config: %s", err)
+	}
+
+	if config.Metrics.Namespace != "kibana" {
+		t.Errorf("expected namespace kibana, got %q", config.Metrics.Namespace)
+	}
+
+	if err := ValidateConstLabels(config.Metrics.ConstLabels); err != nil {
+		t.Errorf("sample const labels are invalid: %s", err)
+	}
+
+	_, err = newMetricFilter(config.Metrics.Include, config.Metrics.Exclude, config.Metrics.Relabel)
+	if err != nil {
+		t.Errorf("sample metric filter is invalid: %s", err)
+	}
+
+	if len(config.CustomMetrics) != 2 {
+		t.Fatalf("expected 2 custom metrics endpoints, got %d", len(config.CustomMetrics))
+	}
+
+	if config.CustomMetrics[0].Interval != time.Minute {
+		t.Errorf("expected interval to be parsed as a duration, got %s", config.CustomMetrics[0].Interval)
+	}
+
+	_, err = NewCustomMetricsExporter("kibana", nil, &KibanaCollector{}, config.CustomMetrics)
+	if err != nil {
+		t.Errorf("sample custom metrics config is invalid: %s", err)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte("custom_metric: []\n"), 0o600)
+	if err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	_, err = LoadConfig(path)
+	if err == nil {
+		t.Errorf("expected error for unknown config field")
+	}
+}
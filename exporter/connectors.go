@@ -0,0 +1,156 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// https://www.elastic.co/guide/en/kibana/8.7/get-all-connectors-api.html
+const connectorsPath = "/api/actions/connectors"
+
+func init() {
+	registerCollector("connectors", "Kibana alerting connectors", false, func(s *collectorSettings) (subCollector, error) {
+		return NewConnectorsExporter(s.namespace, s.constLabels, s.collector)
+	})
+}
+
+// Connector is used to unmarshal a single connector from the connectors
+// API response from Kibana.
+type Connector struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	ConnectorTypeID  string `json:"connector_type_id"`
+	IsPreconfigured  bool   `json:"is_preconfigured"`
+	IsDeprecated     bool   `json:"is_deprecated"`
+	IsMissingSecrets bool   `json:"is_missing_secrets"`
+	// not returned by all Kibana versions, nil when absent
+	ReferencedByCount *int `json:"referenced_by_count"`
+}
+
+// ConnectorsExporter is the connectors collector, exporting the Kibana
+// alerting connectors (actions).
+type ConnectorsExporter struct {
+	lock      sync.Mutex
+	collector *KibanaCollector
+
+	// metrics
+	connectors   *prometheus.GaugeVec
+	info         *prometheus.GaugeVec
+	referencedBy *prometheus.GaugeVec
+}
+
+// NewConnectorsExporter will create a ConnectorsExporter struct and
+// initialize the connector metrics.
+func NewConnectorsExporter(namespace string, constLabels prometheus.Labels, collector *KibanaCollector) (*ConnectorsExporter, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		return nil, errors.New("namespace cannot be empty")
+	}
+
+	exporter := &ConnectorsExporter{
+		collector: collector,
+
+		connectors: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "connectors",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana connector count by connector type",
+			},
+			[]string{"connector_type", "is_preconfigured", "is_deprecated"}),
+		info: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "connector_info",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana connector details, always 1",
+			},
+			[]string{"id", "name", "connector_type", "is_preconfigured", "is_deprecated", "is_missing_secrets"}),
+		referencedBy: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "connector_referenced_by_count",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Number of saved objects referencing the Kibana connector",
+			},
+			[]string{"id", "name", "connector_type"}),
+	}
+
+	return exporter, nil
+}
+
+// scrapeConnectors will return the connectors configured in Kibana.
+func (c *KibanaCollector) scrapeConnectors(ctx context.Context) ([]Connector, error) {
+	respContent, err := c.request(ctx, http.MethodGet, connectorsPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading Kibana connectors: %s", err)
+	}
+
+	var connectors []Connector
+	err = json.Unmarshal(respContent, &connectors)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling Kibana connectors: %s\nProblematic content:\n%s", err, respContent)
+	}
+
+	return connectors, nil
+}
+
+// parseConnectors will set the connector metrics values using the list
+// of connectors returned from Kibana.
+func (e *ConnectorsExporter) parseConnectors(connectors []Connector) {
+	e.connectors.Reset()
+	e.info.Reset()
+	e.referencedBy.Reset()
+
+	for _, c := range connectors {
+		preconfigured := strconv.FormatBool(c.IsPreconfigured)
+		deprecated := strconv.FormatBool(c.IsDeprecated)
+
+		e.connectors.WithLabelValues(c.ConnectorTypeID, preconfigured, deprecated).Inc()
+		e.info.WithLabelValues(
+			c.ID,
+			c.Name,
+			c.ConnectorTypeID,
+			preconfigured,
+			deprecated,
+			strconv.FormatBool(c.IsMissingSecrets),
+		).Set(1)
+
+		if c.ReferencedByCount != nil {
+			e.referencedBy.WithLabelValues(c.ID, c.Name, c.ConnectorTypeID).Set(float64(*c.ReferencedByCount))
+		}
+	}
+}
+
+// Describe is the ConnectorsExporter implementing subCollector
+func (e *ConnectorsExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.connectors.Describe(ch)
+	e.info.Describe(ch)
+	e.referencedBy.Describe(ch)
+}
+
+func (e *ConnectorsExporter) update(s *scrape, ch chan<- prometheus.Metric) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	connectors, err := e.collector.scrapeConnectors(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	e.parseConnectors(connectors)
+
+	e.connectors.Collect(ch)
+	e.info.Collect(ch)
+	e.referencedBy.Collect(ch)
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package exporter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseConnectors(t *testing.T) {
+	var connectors []Connector
+	err := json.Unmarshal([]byte(`[
+		{"id":"1","name":"ops slack","connector_type_id":".slack","is_preconfigured":false,"is_deprecated":false,"is_missing_secrets":true,"referenced_by_count":3},
+		{"id":"2","name":"dev slack","connector_type_id":".slack","is_preconfigured":false,"is_deprecated":false,"is_missing_secrets":false,"referenced_by_count":0},
+		{"id":"3","name":"preconfigured email","connector_type_id":".email","is_preconfigured":true,"is_deprecated":true}
+	]`), &connectors)
+	if err != nil {
+		t.Fatalf("unexpected error while unmarshalling connectors: %s", err)
+	}
+
+	e, err := NewConnectorsExporter("kibana", nil, &KibanaCollector{})
+	if err != nil {
+		t.Fatalf("NewConnectorsExporter failed with valid input")
+	}
+
+	e.parseConnectors(connectors)
+
+	if v := testutil.ToFloat64(e.connectors.WithLabelValues(".slack", "false", "false")); v != 2 {
+		t.Errorf("expected 2 slack connectors, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(e.info.WithLabelValues("1", "ops slack", ".slack", "false", "false", "true")); v != 1 {
+		t.Errorf("expected info metric for connector with missing secrets, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(e.referencedBy.WithLabelValues("1", "ops slack", ".slack")); v != 3 {
+		t.Errorf("expected referenced by count 3, got %f", v)
+	}
+
+	// connectors without a referenced_by_count should not be exported
+	if c := testutil.CollectAndCount(e.referencedBy); c != 2 {
+		t.Errorf("expected 2 referenced by series, got %d", c)
+	}
+}
@@ -0,0 +1,413 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// label expression that resolves to the object key or the array index
+	// of the element being iterated with for_each
+	customMetricKeyExpr = "@key"
+)
+
+var (
+	customMetricTypes = map[string]prometheus.ValueType{
+		"gauge":   prometheus.GaugeValue,
+		"counter": prometheus.CounterValue,
+		"untyped": prometheus.UntypedValue,
+	}
+)
+
+func init() {
+	registerCollector("custom", "metrics declared in the custom_metrics section of the config file", true, func(s *collectorSettings) (subCollector, error) {
+		if len(s.customMetrics) == 0 {
+			return nil, nil
+		}
+
+		return NewCustomMetricsExporter(s.namespace, s.constLabels, s.collector, s.customMetrics)
+	})
+}
+
+// CustomMetricsEndpoint describes a Kibana API endpoint and the metrics
+// that should be extracted from its JSON response.
+type CustomMetricsEndpoint struct {
+	// Path is the Kibana API path, ex: /api/task_manager/_health
+	Path string `yaml:"path"`
+
+	// Method is the HTTP method to use, defaults to GET
+	Method string `yaml:"method"`
+
+	// Body is the optional JSON request body for POST requests
+	Body string `yaml:"body"`
+
+	// Interval is the minimum time between two requests to the endpoint.
+	// Scrapes within the interval are served the previous result. Zero
+	// means the endpoint is requested on every scrape.
+	Interval time.Duration `yaml:"interval"`
+
+	Metrics []CustomMetric `yaml:"metrics"`
+}
+
+// CustomMetric maps a value in a JSON response to a metric. All
+// expressions use the gjson path syntax,
+// https://github.com/tidwall/gjson/blob/master/SYNTAX.md
+type CustomMetric struct {
+	// Name is the metric name, without the namespace
+	Name string `yaml:"name"`
+
+	// Type is one of gauge, counter, or untyped, defaults to gauge
+	Type string `yaml:"type"`
+
+	Help string `yaml:"help"`
+
+	// ForEach is an optional expression resolving to an array or an
+	// object. When provided, a sample is produced for each element, and
+	// Value and Labels are evaluated relative to the element.
+	ForEach string `yaml:"for_each"`
+
+	// Value is the expression resolving to the sample value. Numbers are
+	// used as is, booleans become 1 or 0, and strings are looked up in
+	// ValueMap before being parsed as a number.
+	Value string `yaml:"value"`
+
+	// Labels maps label names to expressions resolving to the label
+	// value. "@key" resolves to the key or the index of the element
+	// being iterated with ForEach.
+	Labels map[string]string `yaml:"labels"`
+
+	// ValueMap maps string values to numbers, ex: green: 1
+	ValueMap map[string]float64 `yaml:"value_map"`
+}
+
+type customMetric struct {
+	config     CustomMetric
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	labelNames []string
+}
+
+type customEndpoint struct {
+	config  CustomMetricsEndpoint
+	metrics []*customMetric
+
+	lastFetch time.Time
+	cache     []prometheus.Metric
+}
+
+// CustomMetricsExporter is the custom collector, exporting the metrics
+// declared in the custom_metrics section of the config file.
+type CustomMetricsExporter struct {
+	lock      sync.Mutex
+	collector *KibanaCollector
+	endpoints []*customEndpoint
+}
+
+// NewCustomMetricsExporter will validate the custom metrics
+// configuration and create a CustomMetricsExporter struct.
+func NewCustomMetricsExporter(namespace string, constLabels prometheus.Labels, collector *KibanaCollector, endpoints []CustomMetricsEndpoint) (*CustomMetricsExporter, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		return nil, errors.New("namespace cannot be empty")
+	}
+
+	exporter := &CustomMetricsExporter{
+		collector: collector,
+	}
+
+	names := map[string]bool{}
+	for _, ec := range endpoints {
+		if !strings.HasPrefix(ec.Path, "/") {
+			return nil, fmt.Errorf("custom metrics path should start with /: %q", ec.Path)
+		}
+
+		ec.Method = strings.ToUpper(strings.TrimSpace(ec.Method))
+		if ec.Method == "" {
+			ec.Method = http.MethodGet
+		}
+
+		if ec.Method != http.MethodGet && ec.Method != http.MethodPost {
+			return nil, fmt.Errorf("unsupported method %s for custom metrics path %s", ec.Method, ec.Path)
+		}
+
+		if ec.Interval < 0 {
+			return nil, fmt.Errorf("interval cannot be negative for custom metrics path %s", ec.Path)
+		}
+
+		endpoint := &customEndpoint{config: ec}
+		for _, mc := range ec.Metrics {
+			m, err := newCustomMetric(namespace, constLabels, ec.Path, mc)
+			if err != nil {
+				return nil, err
+			}
+
+			fqName := prometheus.BuildFQName(namespace, "", mc.Name)
+			if names[fqName] {
+				return nil, fmt.Errorf("custom metric %s is declared more than once", fqName)
+			}
+
+			names[fqName] = true
+			endpoint.metrics = append(endpoint.metrics, m)
+		}
+
+		exporter.endpoints = append(exporter.endpoints, endpoint)
+	}
+
+	return exporter, nil
+}
+
+func newCustomMetric(namespace string, constLabels prometheus.Labels, path string, mc CustomMetric) (*customMetric, error) {
+	fqName := prometheus.BuildFQName(namespace, "", mc.Name)
+	if mc.Name == "" || !model.IsValidMetricName(model.LabelValue(fqName)) {
+		return nil, fmt.Errorf("invalid custom metric name %q for path %s", mc.Name, path)
+	}
+
+	if mc.Value == "" {
+		return nil, fmt.Errorf("custom metric %s has no value expression", fqName)
+	}
+
+	if mc.Type == "" {
+		mc.Type = "gauge"
+	}
+
+	valueType, ok := customMetricTypes[strings.ToLower(mc.Type)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %s for custom metric %s", mc.Type, fqName)
+	}
+
+	if mc.Help == "" {
+		mc.Help = fmt.Sprintf("Custom metric from Kibana API %s", path)
+	}
+
+	if mc.ForEach != "" && len(mc.Labels) == 0 {
+		return nil, fmt.Errorf("custom metric %s uses for_each without labels, add a label or %s to tell the samples apart", fqName, customMetricKeyExpr)
+	}
+
+	var labelNames []string
+	for name := range mc.Labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q for custom metric %s", name, fqName)
+		}
+
+		if _, ok := constLabels[name]; ok {
+			return nil, fmt.Errorf("label %s of custom metric %s is also a const label", name, fqName)
+		}
+
+		labelNames = append(labelNames, name)
+	}
+
+	// map iteration order is random, the label order should be stable
+	sort.Strings(labelNames)
+
+	return &customMetric{
+		config:     mc,
+		desc:       prometheus.NewDesc(fqName, mc.Help, labelNames, constLabels),
+		valueType:  valueType,
+		labelNames: labelNames,
+	}, nil
+}
+
+// evaluate will build the samples for the metric from the given JSON
+// response body. Elements of for_each that resolve to the labels of an
+// earlier element are dropped, as the series would be duplicated.
+func (m *customMetric) evaluate(body []byte) []prometheus.Metric {
+	root := gjson.ParseBytes(body)
+	if m.config.ForEach == "" {
+		if metric := m.sample(root, m.labelValues(root, "")); metric != nil {
+			return []prometheus.Metric{metric}
+		}
+
+		return nil
+	}
+
+	var metrics []prometheus.Metric
+	seen := map[string]bool{}
+	index := 0
+	root.Get(m.config.ForEach).ForEach(func(key, element gjson.Result) bool {
+		k := key.String()
+		if !key.Exists() {
+			// arrays don't have keys, use the index instead
+			k = strconv.Itoa(index)
+		}
+
+		labelValues := m.labelValues(element, k)
+		series := strings.Join(labelValues, "\xff")
+		if seen[series] {
+			log.Warn().
+				Msgf("dropping a duplicate sample of custom metric %s for element %s, the labels do not tell the elements apart", m.config.Name, k)
+		} else if metric := m.sample(element, labelValues); metric != nil {
+			seen[series] = true
+			metrics = append(metrics, metric)
+		}
+
+		index++
+		return true
+	})
+
+	return metrics
+}
+
+// labelValues will evaluate the label expressions against the given JSON
+// element, in the order of the label names.
+func (m *customMetric) labelValues(element gjson.Result, key string) []string {
+	labelValues := make([]string, 0, len(m.labelNames))
+	for _, name := range m.labelNames {
+		expr := m.config.Labels[name]
+		if expr == customMetricKeyExpr {
+			labelValues = append(labelValues, key)
+			continue
+		}
+
+		labelValues = append(labelValues, element.Get(expr).String())
+	}
+
+	return labelValues
+}
+
+// sample will build a single sample with the value evaluated against the
+// given JSON element.
+func (m *customMetric) sample(element gjson.Result, labelValues []string) prometheus.Metric {
+	value, ok := m.toFloat(element.Get(m.config.Value))
+	if !ok {
+		log.Debug().
+			Msgf("no numeric value found at %s for custom metric %s", m.config.Value, m.config.Name)
+		return nil
+	}
+
+	metric, err := prometheus.NewConstMetric(m.desc, m.valueType, value, labelValues...)
+	if err != nil {
+		log.Warn().
+			Msgf("error while building custom metric %s: %s", m.config.Name, err)
+		return nil
+	}
+
+	return metric
+}
+
+func (m *customMetric) toFloat(r gjson.Result) (float64, bool) {
+	switch r.Type {
+	case gjson.Number:
+		return r.Float(), true
+	case gjson.True:
+		return 1, true
+	case gjson.False:
+		return 0, true
+	case gjson.String:
+		if val, ok := m.config.ValueMap[r.String()]; ok {
+			return val, true
+		}
+
+		val, err := strconv.ParseFloat(r.String(), 64)
+		return val, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// describer is a prometheus.Collector that only has descriptors, used to
+// check the metric names for clashes with a registry.
+type describer func(ch chan<- *prometheus.Desc)
+
+func (d describer) Describe(ch chan<- *prometheus.Desc) {
+	d(ch)
+}
+
+func (d describer) Collect(_ chan<- prometheus.Metric) {}
+
+// checkNames will return an error if a custom metric has the name of one
+// of the metrics described by builtin.
+func (e *CustomMetricsExporter) checkNames(builtin describer) error {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(builtin); err != nil {
+		return fmt.Errorf("error while describing the built-in metrics: %s", err)
+	}
+
+	for _, endpoint := range e.endpoints {
+		for _, m := range endpoint.metrics {
+			desc := m.desc
+			err := reg.Register(describer(func(ch chan<- *prometheus.Desc) {
+				ch <- desc
+			}))
+			if err != nil {
+				return fmt.Errorf("custom metric %s for path %s clashes with a built-in metric, choose another name", m.config.Name, endpoint.config.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetch will request the endpoint from Kibana and build the samples for
+// all of its metrics.
+func (e *customEndpoint) fetch(ctx context.Context, collector *KibanaCollector) ([]prometheus.Metric, error) {
+	var body io.Reader
+	if e.config.Body != "" {
+		body = strings.NewReader(e.config.Body)
+	}
+
+	respContent, err := collector.request(ctx, e.config.Method, e.config.Path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if !gjson.ValidBytes(respContent) {
+		return nil, fmt.Errorf("response from %s is not valid JSON", e.config.Path)
+	}
+
+	var metrics []prometheus.Metric
+	for _, m := range e.metrics {
+		metrics = append(metrics, m.evaluate(respContent)...)
+	}
+
+	return metrics, nil
+}
+
+// Describe is the CustomMetricsExporter implementing subCollector
+func (e *CustomMetricsExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, endpoint := range e.endpoints {
+		for _, m := range endpoint.metrics {
+			ch <- m.desc
+		}
+	}
+}
+
+// update will export the metrics of all the endpoints, serving the
+// previous result of the endpoints that are within their interval. The
+// error of the last endpoint that failed is returned.
+func (e *CustomMetricsExporter) update(s *scrape, ch chan<- prometheus.Metric) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var lastErr error
+	for _, endpoint := range e.endpoints {
+		if endpoint.config.Interval == 0 || time.Since(endpoint.lastFetch) >= endpoint.config.Interval {
+			metrics, err := endpoint.fetch(s.ctx, e.collector)
+			if err != nil {
+				lastErr = fmt.Errorf("error while scraping custom metrics from %s: %s", endpoint.config.Path, err)
+				continue
+			}
+
+			endpoint.cache = metrics
+			endpoint.lastFetch = time.Now()
+		}
+
+		for _, metric := range endpoint.cache {
+			ch <- metric
+		}
+	}
+
+	return lastErr
+}
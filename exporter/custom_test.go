@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var customMetricsConfigTests = []struct {
+	desc     string
+	endpoint CustomMetricsEndpoint
+}{
+	{
+		desc:     "relative path",
+		endpoint: CustomMetricsEndpoint{Path: "api/status", Metrics: []CustomMetric{{Name: "a", Value: "a"}}},
+	},
+	{
+		desc:     "unsupported method",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Method: "DELETE", Metrics: []CustomMetric{{Name: "a", Value: "a"}}},
+	},
+	{
+		desc:     "invalid metric name",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Metrics: []CustomMetric{{Name: "a-b", Value: "a"}}},
+	},
+	{
+		desc:     "missing value",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Metrics: []CustomMetric{{Name: "a"}}},
+	},
+	{
+		desc:     "unsupported type",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Metrics: []CustomMetric{{Name: "a", Type: "histogram", Value: "a"}}},
+	},
+	{
+		desc:     "invalid label name",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Metrics: []CustomMetric{{Name: "a", Value: "a", Labels: map[string]string{"__a": "b"}}}},
+	},
+	{
+		desc:     "for_each without labels",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Metrics: []CustomMetric{{Name: "a", ForEach: "a", Value: "b"}}},
+	},
+	{
+		desc: "duplicate metric",
+		endpoint: CustomMetricsEndpoint{Path: "/api/status", Metrics: []CustomMetric{
+			{Name: "a", Value: "a"},
+			{Name: "a", Value: "b"},
+		}},
+	},
+}
+
+func TestNewCustomMetricsExporterInvalidConfig(t *testing.T) {
+	for _, ct := range customMetricsConfigTests {
+		t.Run(ct.desc, func(t *testing.T) {
+			_, err := NewCustomMetricsExporter("kibana", nil, &KibanaCollector{}, []CustomMetricsEndpoint{ct.endpoint})
+			if err == nil {
+				t.Errorf("expected error for invalid custom metrics config")
+			}
+		})
+	}
+}
+
+func TestNewCustomMetricsExporterConstLabelClash(t *testing.T) {
+	_, err := NewCustomMetricsExporter("kibana", prometheus.Labels{"cluster": "prod"}, &KibanaCollector{}, []CustomMetricsEndpoint{
+		{Path: "/api/status", Metrics: []CustomMetric{{Name: "a", Value: "a", Labels: map[string]string{"cluster": "name"}}}},
+	})
+	if err == nil {
+		t.Errorf("expected error for a custom metric label that is also a const label")
+	}
+}
+
+func TestNewExporterCustomMetricsBuiltinNames(t *testing.T) {
+	// exported by an enabled collector, a disabled collector, the other
+	// naming scheme, the exporter itself, the synthetic probes, and the
+	// remote write
+	names := []string{
+		"concurrent_connections",
+		"reporting_queue_depth",
+		"process_uptime_seconds",
+		"up",
+		"probe_success",
+		"exporter_remote_write_queue_length",
+	}
+
+	for _, name := range names {
+		_, err := NewExporter("kibana", &KibanaCollector{}, WithCustomMetrics([]CustomMetricsEndpoint{
+			{Path: "/api/status", Metrics: []CustomMetric{{Name: name, Value: "a"}}},
+		}))
+		if err == nil {
+			t.Errorf("expected error for custom metric %s clashing with a built-in metric", name)
+		}
+	}
+
+	_, err := NewExporter("kibana", &KibanaCollector{}, WithCustomMetrics([]CustomMetricsEndpoint{
+		{Path: "/api/status", Metrics: []CustomMetric{{Name: "custom_status", Value: "a"}}},
+	}))
+	if err != nil {
+		t.Errorf("NewExporter failed with a custom metric that does not clash: %s", err)
+	}
+}
+
+func TestCustomMetricsExporterDuplicateSeries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"indices": [{"name": "logs", "docs": 3}, {"name": "logs", "docs": 5}, {"name": "metrics", "docs": 1}]}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewCustomMetricsExporter("kibana", nil, collector, []CustomMetricsEndpoint{
+		{
+			Path: "/api/custom",
+			Metrics: []CustomMetric{
+				{Name: "custom_docs", ForEach: "indices", Value: "docs", Labels: map[string]string{"index": "name"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCustomMetricsExporter failed with valid input: %s", err)
+	}
+
+	// the second logs element is dropped instead of failing the scrape
+	expected := `
+# HELP kibana_custom_docs Custom metric from Kibana API /api/custom
+# TYPE kibana_custom_docs gauge
+kibana_custom_docs{index="logs"} 3
+kibana_custom_docs{index="metrics"} 1
+`
+
+	if err := testutil.CollectAndCompare(testCollector{subCollector: e}, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected custom metrics output: %s", err)
+	}
+}
+
+func TestCustomMetricsExporterCollect(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{
+			"status": "warn",
+			"enabled": true,
+			"task_types": {"alerting:.es-query": {"count": 3}, "actions:.slack": {"count": 1}},
+			"plugins": [{"id": "alerting", "ms": "12.5"}, {"id": "actions", "ms": "n/a"}]
+		}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewCustomMetricsExporter("kibana", nil, collector, []CustomMetricsEndpoint{
+		{
+			Path:     "/api/custom",
+			Interval: time.Hour,
+			Metrics: []CustomMetric{
+				{Name: "custom_status", Value: "status", ValueMap: map[string]float64{"OK": 1, "warn": 0.5}},
+				{Name: "custom_enabled", Value: "enabled"},
+				{Name: "custom_tasks", ForEach: "task_types", Value: "count", Labels: map[string]string{"task_type": "@key"}},
+				{Name: "custom_plugin_ms", ForEach: "plugins", Value: "ms", Labels: map[string]string{"plugin": "id", "index": "@key"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCustomMetricsExporter failed with valid input: %s", err)
+	}
+
+	expected := `
+# HELP kibana_custom_enabled Custom metric from Kibana API /api/custom
+# TYPE kibana_custom_enabled gauge
+kibana_custom_enabled 1
+# HELP kibana_custom_plugin_ms Custom metric from Kibana API /api/custom
+# TYPE kibana_custom_plugin_ms gauge
+kibana_custom_plugin_ms{index="0",plugin="alerting"} 12.5
+# HELP kibana_custom_status Custom metric from Kibana API /api/custom
+# TYPE kibana_custom_status gauge
+kibana_custom_status 0.5
+# HELP kibana_custom_tasks Custom metric from Kibana API /api/custom
+# TYPE kibana_custom_tasks gauge
+kibana_custom_tasks{task_type="actions:.slack"} 1
+kibana_custom_tasks{task_type="alerting:.es-query"} 3
+`
+
+	if err := testutil.CollectAndCompare(testCollector{subCollector: e}, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected custom metrics output: %s", err)
+	}
+
+	// second collection is within the interval and should be cached
+	if err := testutil.CollectAndCompare(testCollector{subCollector: e}, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected cached custom metrics output: %s", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request to Kibana within the interval, got %d", requests)
+	}
+}
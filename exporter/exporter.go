@@ -1,307 +1,468 @@
 package exporter
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
-var (
-	// https://github.com/elastic/kibana/blob/12466d8b17d8557ff0b561c346511bd1760da4c1/packages/core/status/core-status-common/src/service_status.ts
-	statusLevels = map[string]float64{
-		// everything is working
-		"available": 1,
-		// some features may not be working
-		"degraded": 0.5,
-		// the service is unavailble, but other functions that do not depend on this service should work.
-		"unavailable": 0.25,
-		// block all user functions and display the status page, reserved for Core services only.
-		"critical": 0,
+// Option configures optional behaviour of the Exporter.
+type Option func(*Exporter)
+
+// WithLegacyStatus controls whether the status levels are also exported
+// as the float encoded kibana_status, kibana_core_es_status, and
+// kibana_core_savedobjects_status gauges. Enabled by default.
+func WithLegacyStatus(enabled bool) Option {
+	return func(e *Exporter) {
+		e.settings.legacyStatus = enabled
 	}
-)
+}
+
+// WithNaming sets the naming scheme of the exported metrics, one of
+// NamingLegacy, NamingStandard, or NamingBoth. Defaults to NamingLegacy.
+func WithNaming(naming string) Option {
+	return func(e *Exporter) {
+		e.settings.naming = naming
+	}
+}
+
+// WithConstLabels sets the labels added to every exported metric. The
+// labels should be checked with ValidateConstLabels.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(e *Exporter) {
+		e.settings.constLabels = labels
+	}
+}
+
+// WithCollectors sets the names of the collectors to run on each scrape.
+// Defaults to the collectors that are enabled by default.
+func WithCollectors(names []string) Option {
+	return func(e *Exporter) {
+		e.enabled = names
+	}
+}
+
+// WithCustomMetrics sets the endpoints for the custom collector, from the
+// custom_metrics section of the config file.
+func WithCustomMetrics(endpoints []CustomMetricsEndpoint) Option {
+	return func(e *Exporter) {
+		e.settings.customMetrics = endpoints
+	}
+}
+
+// WithReportingInterval sets the minimum time between two walks of the
+// reporting jobs list, scrapes within the interval are served the
+// previous counts. Defaults to 1m.
+func WithReportingInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.settings.reportingInterval = interval
+	}
+}
+
+// WithContext sets the context of the Kibana requests made on a scrape,
+// cancelling it stops the requests in flight, ex: on shutdown. Defaults to
+// context.Background().
+func WithContext(ctx context.Context) Option {
+	return func(e *Exporter) {
+		e.ctx = ctx
+	}
+}
+
+// WithMinInterval sets the minimum interval between two collections,
+// during which the metrics of the last collection are served without
+// requesting Kibana. Defaults to 0, which only shares the collection
+// between concurrent scrapes.
+func WithMinInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.minInterval = interval
+	}
+}
+
+// collection is the metrics gathered for a Collect call, shared with
+// the concurrent calls.
+type collection struct {
+	at      time.Time
+	done    chan struct{}
+	metrics []prometheus.Metric
+
+	// up is whether Kibana responded to the status request
+	up bool
+}
 
 // Exporter implements the prometheus.Collector interface. This will
 // be used to register the metrics with Prometheus.
 type Exporter struct {
-	lock      sync.RWMutex
+	// ctx is the parent context of the Kibana requests
+	ctx       context.Context
 	collector *KibanaCollector
+	tls       *tlsMetrics
+	breaker   *breakerMetrics
+
+	// settings are passed on to the collector factories
+	settings *collectorSettings
+
+	// enabled is the names of the collectors to build
+	enabled []string
+
+	// collectors run in parallel on each scrape
+	collectors []namedCollector
+
+	// inflight is the collection in progress, and last the latest
+	// finished one, reused for minInterval, both guarded by lock
+	lock        sync.Mutex
+	inflight    *collection
+	last        *collection
+	minInterval time.Duration
 
 	// metrics
-	status                prometheus.Gauge
-	coreESStatus          prometheus.Gauge
-	coreSOStatus          prometheus.Gauge
-	concurrentConnections prometheus.Gauge
-	uptime                prometheus.Gauge
-	heapTotal             prometheus.Gauge
-	heapUsed              prometheus.Gauge
-	resSetSize            prometheus.Gauge
-	eventLoopDelay        prometheus.Gauge
-	load1m                prometheus.Gauge
-	load5m                prometheus.Gauge
-	load15m               prometheus.Gauge
-	osMemTotal            prometheus.Gauge
-	osMemUsed             prometheus.Gauge
-	respTimeAvg           prometheus.Gauge
-	respTimeMax           prometheus.Gauge
-	reqDisconnects        prometheus.Gauge
-	reqTotal              prometheus.Gauge
+	sharedScrapes     prometheus.Counter
+	up                *prometheus.Desc
+	collectorDuration *prometheus.Desc
+	collectorSuccess  *prometheus.Desc
 }
 
-// NewExporter will create a Exporter struct and initialize the metrics
-// that will be scraped by Prometheus. It will use the provided Kibana
-// details to populate a KibanaCollector struct.
-func NewExporter(namespace string, collector *KibanaCollector) (*Exporter, error) {
+// NewExporter will create a Exporter struct and initialize the collectors
+// that will be scraped by Prometheus, using the provided KibanaCollector
+// to talk to Kibana.
+func NewExporter(namespace string, collector *KibanaCollector, opts ...Option) (*Exporter, error) {
 	namespace = strings.TrimSpace(namespace)
 	if namespace == "" {
 		return nil, errors.New("namespace cannot be empty")
 	}
 
+	if !validNamespace(namespace) {
+		return nil, fmt.Errorf("invalid namespace %q, should be a valid metric name prefix", namespace)
+	}
+
 	exporter := &Exporter{
+		ctx:       context.Background(),
 		collector: collector,
 
-		status: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "status",
-				Help:      "Kibana overall status",
-				Namespace: namespace,
-			}),
-		coreESStatus: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "core_es_status",
-				Help:      "Kibana Elasticsearch connectivity status",
-				Namespace: namespace,
-			}),
-		coreSOStatus: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "core_savedobjects_status",
-				Help:      "Kibana SavedObjects service status",
-				Namespace: namespace,
-			}),
-		concurrentConnections: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "concurrent_connections",
-				Namespace: namespace,
-				Help:      "Kibana Concurrent Connections",
-			}),
-		uptime: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "millis_uptime",
-				Namespace: namespace,
-				Help:      "Kibana uptime in milliseconds",
-			}),
-		heapTotal: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "heap_max_in_bytes",
-				Namespace: namespace,
-				Help:      "Kibana process Heap maximum in bytes",
-			}),
-		heapUsed: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "heap_used_in_bytes",
-				Namespace: namespace,
-				Help:      "Kibana process Heap usage in bytes",
-			}),
-		resSetSize: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "resident_set_size_in_bytes",
-				Namespace: namespace,
-				Help:      "Kibana Memory Resident Set Size in bytes",
-			}),
-		eventLoopDelay: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "event_loop_delay",
-				Namespace: namespace,
-				Help:      "Kibana NodeJS Event Loop Delay in milliseconds",
-			}),
-		load1m: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "os_load_1m",
-				Namespace: namespace,
-				Help:      "Kibana load average 1m",
-			}),
-		load5m: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "os_load_5m",
-				Namespace: namespace,
-				Help:      "Kibana load average 5m",
-			}),
-		load15m: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "os_load_15m",
-				Namespace: namespace,
-				Help:      "Kibana load average 15m",
-			}),
-		osMemTotal: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "os_memory_max_in_bytes",
-				Namespace: namespace,
-				Help:      "Kibana memory maximum in bytes",
-			}),
-		osMemUsed: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "os_memory_used_in_bytes",
-				Namespace: namespace,
-				Help:      "Kibana memory used in bytes",
-			}),
-		respTimeAvg: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "response_average",
-				Namespace: namespace,
-				Help:      "Kibana average response time in milliseconds",
-			}),
-		respTimeMax: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "response_max",
-				Namespace: namespace,
-				Help:      "Kibana maximum response time in milliseconds",
-			}),
-		reqDisconnects: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "requests_disconnects",
-				Namespace: namespace,
-				Help:      "Kibana request disconnections count",
-			}),
-		reqTotal: prometheus.NewGauge(
-			prometheus.GaugeOpts{
-				Name:      "requests_total",
-				Namespace: namespace,
-				Help:      "Kibana total request count",
-			}),
+		settings: &collectorSettings{
+			namespace:         namespace,
+			collector:         collector,
+			legacyStatus:      true,
+			naming:            NamingLegacy,
+			reportingInterval: defaultReportingInterval,
+		},
+		enabled: defaultCollectors(),
+	}
+
+	for _, opt := range opts {
+		opt(exporter)
+	}
+
+	// the options can set the const labels
+	exporter.initMetrics()
+
+	if exporter.minInterval < 0 {
+		return nil, fmt.Errorf("invalid minimum interval %s, cannot be negative", exporter.minInterval)
+	}
+
+	if err := checkNaming(exporter.settings.naming); err != nil {
+		return nil, err
+	}
+
+	collectors, err := newCollectors(exporter.enabled, exporter.settings)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range collectors {
+		log.Info().
+			Msgf("enabled collector %s", c.name)
+	}
+
+	exporter.collectors = collectors
+
+	if err := exporter.checkCustomMetrics(); err != nil {
+		return nil, err
 	}
 
 	return exporter, nil
 }
 
-// parseMetrics will set the metrics values using the KibanaMetrics
-// struct, converting values to float64 where needed.
-func (e *Exporter) parseMetrics(m *KibanaMetrics) error {
-	log.Trace().
-		Msg("parsing received metrics from kibana")
+// checkCustomMetrics will return an error if a custom metric has the name
+// of a built-in metric, whatever the naming scheme and whether the
+// collector of the built-in metric is enabled or not. The synthetic probe
+// and remote write metrics are registered alongside the Exporter, so they
+// are checked as well.
+func (e *Exporter) checkCustomMetrics() error {
+	var custom *CustomMetricsExporter
+	for _, c := range e.collectors {
+		if ce, ok := c.subCollector.(*CustomMetricsExporter); ok {
+			custom = ce
+		}
+	}
 
-	if val, ok := statusLevels[strings.ToLower(m.Status.Overall.Level)]; !ok {
-		// absence of this metric will default to critical
-		// initialising is also considered 0
-		e.status.Set(0.0)
-	} else {
-		e.status.Set(val)
+	if custom == nil {
+		return nil
 	}
 
-	if val, ok := statusLevels[strings.ToLower(m.Status.Core.Elasticsearch.Level)]; !ok {
-		// absence of this metric will default to critical
-		// initialising is also considered 0
-		e.coreESStatus.Set(0.0)
-	} else {
-		e.coreESStatus.Set(val)
+	builtin, err := allMetrics(*e.settings)
+	if err != nil {
+		return err
 	}
 
-	if val, ok := statusLevels[strings.ToLower(m.Status.Core.SavedObjects.Level)]; !ok {
-		// absence of this metric will default to critical
-		// initialising is also considered 0
-		e.coreSOStatus.Set(0.0)
-	} else {
-		e.coreSOStatus.Set(val)
-	}
-
-	e.concurrentConnections.Set(float64(m.Metrics.ConcurrentConnections))
-	e.uptime.Set(float64(m.Metrics.Process.UptimeInMillis))
-	e.heapTotal.Set(float64(m.Metrics.Process.Memory.Heap.TotalInBytes))
-	e.heapUsed.Set(float64(m.Metrics.Process.Memory.Heap.UsedInBytes))
-	e.resSetSize.Set(float64(m.Metrics.Process.Memory.ResidentSetSizeInBytes))
-	e.eventLoopDelay.Set(m.Metrics.Process.EventLoopDelayInMillis)
-	e.load1m.Set(m.Metrics.Os.Load.Load1m)
-	e.load5m.Set(m.Metrics.Os.Load.Load5m)
-	e.load15m.Set(m.Metrics.Os.Load.Load15m)
-	e.osMemTotal.Set(float64(m.Metrics.Os.Memory.TotalInBytes))
-	e.osMemUsed.Set(float64(m.Metrics.Os.Memory.UsedInBytes))
-	e.respTimeAvg.Set(m.Metrics.ResponseTimes.AvgInMillis)
-	e.respTimeMax.Set(m.Metrics.ResponseTimes.MaxInMillis)
-	e.reqDisconnects.Set(float64(m.Metrics.Requests.Disconnects))
-	e.reqTotal.Set(float64(m.Metrics.Requests.Total))
-
-	return nil
+	return custom.checkNames(builtin)
+}
+
+// allMetrics returns the descriptors of all the built-in metrics the
+// exporter can export with the namespace and the const labels of the
+// settings, whatever the collectors and the naming scheme, including the
+// synthetic probe and remote write metrics even if they are not
+// configured. The custom metrics are left out.
+func allMetrics(settings collectorSettings) (describer, error) {
+	settings.collector = &KibanaCollector{client: &http.Client{}}
+	settings.legacyStatus = true
+	settings.naming = NamingBoth
+	settings.customMetrics = nil
+
+	collectors, err := newCollectors(collectorNames(), &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{settings: &settings}
+	e.initMetrics()
+
+	prober, err := NewProber(settings.namespace, settings.constLabels, settings.collector, ProbesConfig{
+		Endpoints: []ProbeEndpoint{{Path: "/"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewRemoteWriter(settings.namespace, settings.constLabels, prometheus.NewRegistry(), RemoteWriteConfig{
+		URL: "http://localhost/",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ch chan<- *prometheus.Desc) {
+		for _, c := range collectors {
+			c.Describe(ch)
+		}
+
+		e.describeOwn(ch)
+		prober.Describe(ch)
+		writer.Describe(ch)
+	}, nil
+}
+
+// initMetrics will build the metrics about the exporter and its connection
+// to Kibana, which are not part of a collector.
+func (e *Exporter) initMetrics() {
+	namespace := e.settings.namespace
+	constLabels := e.settings.constLabels
+
+	e.tls = newTLSMetrics(namespace, constLabels)
+	e.breaker = newBreakerMetrics(namespace, constLabels)
+	e.sharedScrapes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "exporter",
+		Name:        "shared_scrapes_total",
+		Help:        "Number of scrapes served from the metrics collected for a concurrent or a recent scrape, without requesting Kibana",
+		ConstLabels: constLabels,
+	})
+	e.up = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "up"),
+		"Whether Kibana responded to the status request of the last scrape",
+		nil,
+		constLabels)
+	e.collectorDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "collector_duration_seconds"),
+		"Time taken by each collector to collect its metrics in seconds",
+		[]string{"collector"},
+		constLabels)
+	e.collectorSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "collector_success"),
+		"Whether each collector succeeded in collecting its metrics",
+		[]string{"collector"},
+		constLabels)
 }
 
-func (e *Exporter) send(ch chan<- prometheus.Metric) error {
-	ch <- e.status
-	ch <- e.coreESStatus
-	ch <- e.coreSOStatus
-	ch <- e.concurrentConnections
-	ch <- e.uptime
-	ch <- e.heapTotal
-	ch <- e.heapUsed
-	ch <- e.resSetSize
-	ch <- e.eventLoopDelay
-	ch <- e.load1m
-	ch <- e.load5m
-	ch <- e.load15m
-	ch <- e.osMemTotal
-	ch <- e.osMemUsed
-	ch <- e.respTimeAvg
-	ch <- e.respTimeMax
-	ch <- e.reqDisconnects
-	ch <- e.reqTotal
-
-	return nil
+// observe will feed the collectors that need to remember previous
+// observations of the Kibana status.
+func (e *Exporter) observe(m *KibanaMetrics, now time.Time) {
+	for _, c := range e.collectors {
+		if o, ok := c.subCollector.(statusObserver); ok {
+			o.observe(m, now)
+		}
+	}
+}
+
+// execute will run a single collector, and export its duration and
+// whether it succeeded.
+func (e *Exporter) execute(c namedCollector, s *scrape, ch chan<- prometheus.Metric) {
+	start := time.Now()
+	err := c.update(s, ch)
+	duration := time.Since(start)
+
+	success := 1.0
+	if err != nil {
+		log.Error().
+			Msgf("error while running collector %s: %s", c.name, err)
+		success = 0
+	} else {
+		log.Debug().
+			Msgf("collector %s succeeded in %s", c.name, duration)
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.collectorDuration, prometheus.GaugeValue, duration.Seconds(), c.name)
+	ch <- prometheus.MustNewConstMetric(e.collectorSuccess, prometheus.GaugeValue, success, c.name)
 }
 
 // Describe is the Exporter implementing prometheus.Collector
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- e.status.Desc()
-	ch <- e.coreESStatus.Desc()
-	ch <- e.coreSOStatus.Desc()
-	ch <- e.concurrentConnections.Desc()
-	ch <- e.uptime.Desc()
-	ch <- e.heapTotal.Desc()
-	ch <- e.heapUsed.Desc()
-	ch <- e.resSetSize.Desc()
-	ch <- e.eventLoopDelay.Desc()
-	ch <- e.load1m.Desc()
-	ch <- e.load5m.Desc()
-	ch <- e.load15m.Desc()
-	ch <- e.osMemTotal.Desc()
-	ch <- e.osMemUsed.Desc()
-	ch <- e.respTimeAvg.Desc()
-	ch <- e.respTimeMax.Desc()
-	ch <- e.reqDisconnects.Desc()
-	ch <- e.reqTotal.Desc()
+	for _, c := range e.collectors {
+		c.Describe(ch)
+	}
+
+	e.describeOwn(ch)
+}
+
+// describeOwn sends the descriptors of the metrics about the exporter and
+// its connection to Kibana, which are not part of a collector.
+func (e *Exporter) describeOwn(ch chan<- *prometheus.Desc) {
+	ch <- e.sharedScrapes.Desc()
+	ch <- e.up
+	ch <- e.collectorDuration
+	ch <- e.collectorSuccess
+	e.tls.describe(ch)
+	e.breaker.describe(ch)
 }
 
-// Collect is the Exporter implementing prometheus.Collector
+// Collect is the Exporter implementing prometheus.Collector. Concurrent
+// calls share a single collection, and so a single Kibana request, which
+// is also reused for the minimum interval if one is set.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	log.Trace().
 		Msg("a Collect() call received")
 
+	c, shared := e.collection(time.Now())
+	if shared {
+		<-c.done
+		e.sharedScrapes.Inc()
+
+		log.Debug().
+			Msgf("serving a shared collection from %s", c.at)
+	} else {
+		c.metrics, c.up = e.gather()
+		e.finish(c)
+	}
+
+	for _, m := range c.metrics {
+		ch <- m
+	}
+
+	ch <- e.sharedScrapes
+}
+
+// KibanaUp returns whether Kibana responded to the status request of the
+// last collection, false if there was none.
+func (e *Exporter) KibanaUp() bool {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
-	log.Trace().
-		Msg("issueing a scrape() call to the collector")
+	return e.last != nil && e.last.up
+}
 
-	metrics, err := e.collector.scrape()
-	if err != nil {
-		log.Error().
-			Msgf("error while scraping metrics from Kibana: %s", err)
-		return
+// collection returns the collection to serve at the given time, and
+// whether it is shared with another call. A collection that is not shared
+// must be gathered and finished by the caller.
+func (e *Exporter) collection(now time.Time) (*collection, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.inflight != nil {
+		return e.inflight, true
 	}
 
-	// output for debugging
-	log.Debug().
-		Interface("metrics", metrics).
-		Msg("returned metrics content")
+	if e.last != nil && e.minInterval > 0 && now.Sub(e.last.at) < e.minInterval {
+		return e.last, true
+	}
 
-	err = e.parseMetrics(metrics)
-	if err != nil {
-		log.Error().
-			Msgf("error while parsing metrics from Kibana: %s", err)
-		return
+	e.inflight = &collection{
+		at:   now,
+		done: make(chan struct{}),
 	}
 
-	err = e.send(ch)
-	if err != nil {
-		log.Error().
-			Msgf("error while responding to Prometheus with metrics: %s", err)
+	return e.inflight, false
+}
+
+// finish will make the collection available to the later calls, and
+// release the calls waiting for it.
+func (e *Exporter) finish(c *collection) {
+	e.lock.Lock()
+	e.inflight = nil
+	e.last = c
+	e.lock.Unlock()
+
+	close(c.done)
+}
+
+// gather will run the collectors, and return the metrics, and
+// whether Kibana responded to the status request.
+func (e *Exporter) gather() ([]prometheus.Metric, bool) {
+	var gathered []prometheus.Metric
+
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range metrics {
+			gathered = append(gathered, m)
+		}
+	}()
+
+	up := e.collect(metrics)
+	close(metrics)
+	<-done
+
+	return gathered, up
+}
+
+// collect will run all the collectors in parallel, sharing a single
+// scrape between them. Returns whether Kibana responded to the status
+// request.
+func (e *Exporter) collect(ch chan<- prometheus.Metric) bool {
+	s := newScrape(e.ctx, e.collector, time.Now())
+
+	var wg sync.WaitGroup
+	for _, c := range e.collectors {
+		wg.Add(1)
+		go func(c namedCollector) {
+			defer wg.Done()
+			e.execute(c, s, ch)
+		}(c)
+	}
+
+	wg.Wait()
+
+	// requests the status if none of the collectors did, a stale status
+	// means Kibana was not requested
+	up := 1.0
+	m, err := s.status()
+	stale := err == nil && m.Stale
+	if err != nil || stale {
+		up = 0
 	}
+
+	ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, up)
+	e.breaker.collect(ch, e.collector.breaker.currentState(), stale)
+
+	// reported even if the collectors failed, ex: due to an untrusted
+	// certificate
+	e.tls.collect(ch, e.collector.tlsObservation())
+
+	return up == 1
 }
@@ -1,7 +1,16 @@
 package exporter
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestNewExporterWithoutNamespace(t *testing.T) {
@@ -10,3 +19,236 @@ func TestNewExporterWithoutNamespace(t *testing.T) {
 		t.Errorf("expected error when invalid namespace was provided")
 	}
 }
+
+func TestNewExporterInvalidNamespace(t *testing.T) {
+	for _, namespace := range []string{"kibana-prod", "1kibana", "kibana prod"} {
+		_, err := NewExporter(namespace, &KibanaCollector{})
+		if err == nil {
+			t.Errorf("expected error for invalid namespace %q", namespace)
+		}
+	}
+}
+
+func TestNewExporterUnknownCollector(t *testing.T) {
+	_, err := NewExporter("kibana", &KibanaCollector{}, WithCollectors([]string{"status", "cpu"}))
+	if err == nil {
+		t.Errorf("expected error when an unknown collector was provided")
+	}
+}
+
+func TestExporterCollect(t *testing.T) {
+	statusRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/status":
+			statusRequests++
+			fmt.Fprint(w, `{"status":{"overall":{"level":"available"},"plugins":{"alerting":{"level":"degraded"}}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewExporter("kibana", collector, WithCollectors([]string{"status", "process", "os", "http", "plugins", "connectors"}))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	expected := `
+# HELP kibana_exporter_collector_success Whether each collector succeeded in collecting its metrics
+# TYPE kibana_exporter_collector_success gauge
+kibana_exporter_collector_success{collector="connectors"} 0
+kibana_exporter_collector_success{collector="http"} 1
+kibana_exporter_collector_success{collector="os"} 1
+kibana_exporter_collector_success{collector="plugins"} 1
+kibana_exporter_collector_success{collector="process"} 1
+kibana_exporter_collector_success{collector="status"} 1
+`
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "kibana_exporter_collector_success")
+	if err != nil {
+		t.Errorf("unexpected collector success output: %s", err)
+	}
+
+	if statusRequests != 1 {
+		t.Errorf("expected the status to be requested once for all collectors, got %d", statusRequests)
+	}
+}
+
+func TestExporterConstLabels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/status":
+			fmt.Fprint(w, `{"status":{"overall":{"level":"available"},"plugins":{"alerting":{"level":"degraded"}}}}`)
+		case "/api/custom":
+			fmt.Fprint(w, `{"count":1}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewExporter("kibana", collector,
+		WithCollectors(collectorNames()),
+		WithNaming(NamingBoth),
+		WithConstLabels(prometheus.Labels{"cluster": "prod"}),
+		WithCustomMetrics([]CustomMetricsEndpoint{
+			{Path: "/api/custom", Metrics: []CustomMetric{{Name: "custom_count", Value: "count"}}},
+		}))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected gather error: %s", err)
+	}
+
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			found := false
+			for _, lp := range m.GetLabel() {
+				found = found || (lp.GetName() == "cluster" && lp.GetValue() == "prod")
+			}
+
+			if !found {
+				t.Errorf("expected the const label on %s %v", mf.GetName(), m.GetLabel())
+			}
+		}
+	}
+}
+
+func TestExporterUp(t *testing.T) {
+	var up atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":{"overall":{"level":"available"}}}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	// none of the collectors request the status
+	e, err := NewExporter("kibana", collector, WithCollectors([]string{"connectors"}))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	for _, expected := range []string{"0", "1"} {
+		err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP kibana_up Whether Kibana responded to the status request of the last scrape
+# TYPE kibana_up gauge
+kibana_up `+expected+`
+`), "kibana_up")
+		if err != nil {
+			t.Errorf("unexpected up output: %s", err)
+		}
+
+		if e.KibanaUp() != (expected == "1") {
+			t.Errorf("expected KibanaUp to match kibana_up %s", expected)
+		}
+
+		up.Store(true)
+	}
+}
+
+func TestExporterCollection(t *testing.T) {
+	e, err := NewExporter("kibana", &KibanaCollector{}, WithCollectors([]string{}))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	now := time.Now()
+	first, shared := e.collection(now)
+	if shared {
+		t.Fatalf("expected the first collection not to be shared")
+	}
+
+	second, shared := e.collection(now)
+	if !shared || second != first {
+		t.Errorf("expected a concurrent call to share the collection in progress")
+	}
+
+	e.finish(first)
+	select {
+	case <-second.done:
+	default:
+		t.Errorf("expected the shared collection to be released when finished")
+	}
+
+	if _, shared := e.collection(now); shared {
+		t.Errorf("expected a new collection once the last one finished without a minimum interval")
+	}
+
+	if _, err := NewExporter("kibana", &KibanaCollector{}, WithMinInterval(-time.Second)); err == nil {
+		t.Errorf("expected an error for a negative minimum interval")
+	}
+}
+
+func TestExporterMinInterval(t *testing.T) {
+	var statusRequests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusRequests.Add(1)
+		fmt.Fprint(w, `{"status":{"overall":{"level":"available"}}}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewExporter("kibana", collector, WithCollectors([]string{"status"}), WithMinInterval(time.Minute))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	for i := 0; i < 3; i++ {
+		if _, err := reg.Gather(); err != nil {
+			t.Fatalf("unexpected gather error: %s", err)
+		}
+	}
+
+	if statusRequests.Load() != 1 {
+		t.Errorf("expected a single status request within the minimum interval, got %d", statusRequests.Load())
+	}
+
+	expected := `
+# HELP kibana_exporter_shared_scrapes_total Number of scrapes served from the metrics collected for a concurrent or a recent scrape, without requesting Kibana
+# TYPE kibana_exporter_shared_scrapes_total counter
+kibana_exporter_shared_scrapes_total 3
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "kibana_exporter_shared_scrapes_total")
+	if err != nil {
+		t.Errorf("unexpected shared scrapes output: %s", err)
+	}
+}
@@ -0,0 +1,363 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+)
+
+// RelabelRule rewrites the name and the labels of the metrics with a
+// matching name.
+type RelabelRule struct {
+	// Metric is the regular expression matched against the full metric
+	// name, anchored at both ends
+	Metric string `yaml:"metric"`
+
+	// Rename is the new metric name, which can refer to the capture
+	// groups of Metric, ex: kibana_$1 or kibana_${1}_total
+	Rename string `yaml:"rename"`
+
+	// SetLabels adds labels, or overwrites the value of existing ones
+	SetLabels map[string]string `yaml:"set_labels"`
+
+	// RenameLabels renames labels, from the current name to the new name
+	RenameLabels map[string]string `yaml:"rename_labels"`
+
+	// DropLabels removes labels
+	DropLabels []string `yaml:"drop_labels"`
+}
+
+type relabelRule struct {
+	RelabelRule
+	metric *regexp.Regexp
+}
+
+// metricFilter drops and rewrites the gathered metric families before
+// they are exported.
+type metricFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	rules   []relabelRule
+}
+
+// compileAnchored compiles a regular expression that should match the
+// whole metric name, like the regular expressions in Prometheus.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %s", expr, err)
+	}
+
+	return re, nil
+}
+
+func validRelabelLabel(name string) bool {
+	return model.LabelName(name).IsValid() && !strings.HasPrefix(name, "__")
+}
+
+// newMetricFilter will compile the include and exclude lists, and the
+// relabel rules. Returns nil if there is nothing to filter.
+func newMetricFilter(include, exclude []string, rules []RelabelRule) (*metricFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 && len(rules) == 0 {
+		return nil, nil
+	}
+
+	f := &metricFilter{}
+	for _, expr := range include {
+		re, err := compileAnchored(expr)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing metrics include list: %s", err)
+		}
+
+		f.include = append(f.include, re)
+	}
+
+	for _, expr := range exclude {
+		re, err := compileAnchored(expr)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing metrics exclude list: %s", err)
+		}
+
+		f.exclude = append(f.exclude, re)
+	}
+
+	for _, rule := range rules {
+		re, err := compileAnchored(rule.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing relabel rule: %s", err)
+		}
+
+		if rule.Rename != "" && !strings.Contains(rule.Rename, "$") && !model.IsValidMetricName(model.LabelValue(rule.Rename)) {
+			return nil, fmt.Errorf("invalid metric name %q in relabel rule for %s", rule.Rename, rule.Metric)
+		}
+
+		for name, value := range rule.SetLabels {
+			if !validRelabelLabel(name) || value == "" {
+				return nil, fmt.Errorf("invalid label %s=%q in relabel rule for %s", name, value, rule.Metric)
+			}
+		}
+
+		for _, to := range rule.RenameLabels {
+			if !validRelabelLabel(to) {
+				return nil, fmt.Errorf("invalid label name %q in relabel rule for %s", to, rule.Metric)
+			}
+		}
+
+		f.rules = append(f.rules, relabelRule{RelabelRule: rule, metric: re})
+	}
+
+	return f, nil
+}
+
+// ParseRenames parses a list of regex=replacement pairs into relabel
+// rules that only rename metrics.
+func ParseRenames(pairs []string) ([]RelabelRule, error) {
+	var rules []RelabelRule
+	for _, pair := range pairs {
+		expr, rename, ok := strings.Cut(pair, "=")
+		if !ok || expr == "" || rename == "" {
+			return nil, fmt.Errorf("metric rename %q should be in the regex=replacement format", pair)
+		}
+
+		rules = append(rules, RelabelRule{Metric: expr, Rename: rename})
+	}
+
+	return rules, nil
+}
+
+// keep returns whether the metric with the given name passes the include
+// and exclude lists.
+func (f *metricFilter) keep(name string) bool {
+	if len(f.include) > 0 {
+		included := false
+		for _, re := range f.include {
+			if re.MatchString(name) {
+				included = true
+				break
+			}
+		}
+
+		if !included {
+			return false
+		}
+	}
+
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// relabel will rewrite the metric family with the relabel rules. Rules
+// are applied in order, each to the result of the previous ones. The
+// family is returned as is if no rule matches.
+func (f *metricFilter) relabel(mf *dto.MetricFamily) (*dto.MetricFamily, error) {
+	name := mf.GetName()
+	newName := name
+
+	var matched []relabelRule
+	for _, rule := range f.rules {
+		match := rule.metric.FindStringSubmatchIndex(newName)
+		if match == nil {
+			continue
+		}
+
+		if rule.Rename != "" {
+			newName = string(rule.metric.ExpandString(nil, rule.Rename, newName, match))
+			if !model.IsValidMetricName(model.LabelValue(newName)) {
+				return nil, fmt.Errorf("relabel rule for %s renamed %s to an invalid metric name %q", rule.Metric, name, newName)
+			}
+		}
+
+		matched = append(matched, rule)
+	}
+
+	if len(matched) == 0 {
+		return mf, nil
+	}
+
+	rewritten := &dto.MetricFamily{
+		Name: &newName,
+		Help: mf.Help,
+		Type: mf.Type,
+	}
+
+	for _, m := range mf.GetMetric() {
+		labels := map[string]string{}
+		for _, lp := range m.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+
+		for _, rule := range matched {
+			for from, to := range rule.RenameLabels {
+				if value, ok := labels[from]; ok {
+					delete(labels, from)
+					labels[to] = value
+				}
+			}
+
+			for _, label := range rule.DropLabels {
+				delete(labels, label)
+			}
+
+			for label, value := range rule.SetLabels {
+				labels[label] = value
+			}
+		}
+
+		// the values are shared with the gathered metric, which is not
+		// changed
+		pb := &dto.Metric{
+			Label:       make([]*dto.LabelPair, 0, len(labels)),
+			Gauge:       m.Gauge,
+			Counter:     m.Counter,
+			Summary:     m.Summary,
+			Untyped:     m.Untyped,
+			Histogram:   m.Histogram,
+			TimestampMs: m.TimestampMs,
+		}
+
+		for label, value := range labels {
+			label, value := label, value
+			pb.Label = append(pb.Label, &dto.LabelPair{Name: &label, Value: &value})
+		}
+
+		sort.Slice(pb.Label, func(i, j int) bool {
+			return pb.Label[i].GetName() < pb.Label[j].GetName()
+		})
+
+		rewritten.Metric = append(rewritten.Metric, pb)
+	}
+
+	return rewritten, nil
+}
+
+// seriesKey returns the labels of the metric as a string, to tell the
+// series of a family apart.
+func seriesKey(m *dto.Metric) string {
+	var b strings.Builder
+	for _, lp := range m.GetLabel() {
+		b.WriteString(lp.GetName())
+		b.WriteByte(0xff)
+		b.WriteString(lp.GetValue())
+		b.WriteByte(0xff)
+	}
+
+	return b.String()
+}
+
+// apply will drop the metric families that do not pass the include and
+// exclude lists, and rewrite the others with the relabel rules. Families
+// renamed to the same name are merged, dropping the series that the
+// relabelling made identical.
+func (f *metricFilter) apply(families []*dto.MetricFamily) []*dto.MetricFamily {
+	var filtered []*dto.MetricFamily
+	byName := map[string]*dto.MetricFamily{}
+	rewritten := map[string]bool{}
+	for _, mf := range families {
+		if !f.keep(mf.GetName()) {
+			continue
+		}
+
+		relabelled, err := f.relabel(mf)
+		if err != nil {
+			log.Warn().
+				Msgf("dropping metric family: %s", err)
+			continue
+		}
+
+		name := relabelled.GetName()
+		if relabelled != mf {
+			rewritten[name] = true
+		}
+
+		existing, ok := byName[name]
+		if !ok {
+			byName[name] = relabelled
+			filtered = append(filtered, relabelled)
+			continue
+		}
+
+		if existing.GetType() != relabelled.GetType() {
+			log.Warn().
+				Msgf("dropping metric family %s relabelled as %s, which has another type", mf.GetName(), name)
+			continue
+		}
+
+		existing.Metric = append(existing.Metric, relabelled.Metric...)
+		rewritten[name] = true
+	}
+
+	for _, mf := range filtered {
+		if !rewritten[mf.GetName()] {
+			continue
+		}
+
+		seen := map[string]bool{}
+		metrics := make([]*dto.Metric, 0, len(mf.Metric))
+		for _, m := range mf.Metric {
+			key := seriesKey(m)
+			if seen[key] {
+				log.Warn().
+					Msgf("dropping a duplicate series of %s after relabelling", mf.GetName())
+				continue
+			}
+
+			seen[key] = true
+			metrics = append(metrics, m)
+		}
+
+		sort.SliceStable(metrics, func(i, j int) bool {
+			return seriesKey(metrics[i]) < seriesKey(metrics[j])
+		})
+
+		mf.Metric = metrics
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].GetName() < filtered[j].GetName()
+	})
+
+	return filtered
+}
+
+// filteredGatherer applies a metricFilter to the metric families of
+// another gatherer.
+type filteredGatherer struct {
+	gatherer prometheus.Gatherer
+	filter   *metricFilter
+}
+
+// NewFilteredGatherer will compile the include and exclude lists, and the
+// relabel rules, and return a gatherer that applies them to all the
+// metrics of the given gatherer. The given gatherer is returned as is if
+// there is nothing to filter.
+func NewFilteredGatherer(gatherer prometheus.Gatherer, include, exclude []string, rules []RelabelRule) (prometheus.Gatherer, error) {
+	filter, err := newMetricFilter(include, exclude, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter == nil {
+		return gatherer, nil
+	}
+
+	return &filteredGatherer{gatherer: gatherer, filter: filter}, nil
+}
+
+// Gather is the filteredGatherer implementing prometheus.Gatherer. The
+// metric families that could be gathered are filtered even if an error is
+// returned.
+func (g *filteredGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	return g.filter.apply(families), err
+}
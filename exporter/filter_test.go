@@ -0,0 +1,228 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var invalidFilterTests = []struct {
+	desc    string
+	include []string
+	exclude []string
+	rules   []RelabelRule
+}{
+	{desc: "invalid include", include: []string{"kibana_("}},
+	{desc: "invalid exclude", exclude: []string{"kibana_["}},
+	{desc: "invalid rule regex", rules: []RelabelRule{{Metric: "(", Rename: "a"}}},
+	{desc: "invalid rename", rules: []RelabelRule{{Metric: "kibana_status", Rename: "kibana-status"}}},
+	{desc: "invalid set label", rules: []RelabelRule{{Metric: ".*", SetLabels: map[string]string{"__a": "b"}}}},
+	{desc: "empty set label", rules: []RelabelRule{{Metric: ".*", SetLabels: map[string]string{"a": ""}}}},
+	{desc: "invalid rename label", rules: []RelabelRule{{Metric: ".*", RenameLabels: map[string]string{"a": "b-c"}}}},
+}
+
+func TestNewMetricFilterInvalid(t *testing.T) {
+	for _, ft := range invalidFilterTests {
+		t.Run(ft.desc, func(t *testing.T) {
+			_, err := newMetricFilter(ft.include, ft.exclude, ft.rules)
+			if err == nil {
+				t.Errorf("expected error for an invalid filter")
+			}
+		})
+	}
+}
+
+func TestMetricFilterKeep(t *testing.T) {
+	f, err := newMetricFilter([]string{"kibana_status.*", "kibana_os_.*"}, []string{"kibana_os_load_.*"}, nil)
+	if err != nil {
+		t.Fatalf("newMetricFilter failed with valid input: %s", err)
+	}
+
+	keep := map[string]bool{
+		"kibana_status":             true,
+		"kibana_status_level":       true,
+		"kibana_os_memory_used":     true,
+		"kibana_os_load_1m":         false,
+		"kibana_millis_uptime":      false,
+		"prefix_kibana_status":      false,
+		"kibana_tls_chain_verified": false,
+	}
+
+	for name, expected := range keep {
+		if f.keep(name) != expected {
+			t.Errorf("expected keep(%s) to be %t", name, expected)
+		}
+	}
+}
+
+func TestFilteredGathererRelabel(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	level := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "kibana_status_level", Help: "Kibana status level"}, []string{"service", "summary"})
+	level.WithLabelValues("overall", "All services are available").Set(1)
+	status := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kibana_status", Help: "Kibana overall status"})
+	status.Set(1)
+	reg.MustRegister(level, status)
+
+	g, err := NewFilteredGatherer(reg, nil, nil, []RelabelRule{
+		{
+			Metric:       "kibana_(.+)_level",
+			Rename:       "kibana_${1}_state",
+			RenameLabels: map[string]string{"service": "component"},
+			DropLabels:   []string{"summary"},
+			SetLabels:    map[string]string{"source": "exporter"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewFilteredGatherer failed with valid input: %s", err)
+	}
+
+	// metrics that do not match any rule are passed through as is
+	expected := `
+# HELP kibana_status Kibana overall status
+# TYPE kibana_status gauge
+kibana_status 1
+# HELP kibana_status_state Kibana status level
+# TYPE kibana_status_state gauge
+kibana_status_state{component="overall",source="exporter"} 1
+`
+
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected relabelled output: %s", err)
+	}
+}
+
+func TestFilteredGathererRelabelHistogram(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "kibana_probe_duration_seconds", Help: "Probe duration", Buckets: []float64{0.1, 1}})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	reg.MustRegister(h)
+
+	g, err := NewFilteredGatherer(reg, nil, nil, []RelabelRule{{Metric: "kibana_probe_(.+)", Rename: "kibana_synthetic_$1"}})
+	if err != nil {
+		t.Fatalf("NewFilteredGatherer failed with valid input: %s", err)
+	}
+
+	expected := `
+# HELP kibana_synthetic_duration_seconds Probe duration
+# TYPE kibana_synthetic_duration_seconds histogram
+kibana_synthetic_duration_seconds_bucket{le="0.1"} 1
+kibana_synthetic_duration_seconds_bucket{le="1"} 2
+kibana_synthetic_duration_seconds_bucket{le="+Inf"} 2
+kibana_synthetic_duration_seconds_sum 0.55
+kibana_synthetic_duration_seconds_count 2
+`
+
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected rewritten histogram output: %s", err)
+	}
+}
+
+func TestFilteredGathererMerge(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	heap := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kibana_heap_used_in_bytes", Help: "Kibana heap used"})
+	heap.Set(10)
+	rss := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kibana_resident_set_size_in_bytes", Help: "Kibana resident set size"})
+	rss.Set(20)
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kibana_os_memory_used_in_bytes", Help: "Kibana host memory used"})
+	other.Set(30)
+	reg.MustRegister(heap, rss, other)
+
+	g, err := NewFilteredGatherer(reg, nil, nil, []RelabelRule{
+		{Metric: "kibana_heap_used_in_bytes", Rename: "kibana_memory_bytes", SetLabels: map[string]string{"area": "heap"}},
+		{Metric: "kibana_resident_set_size_in_bytes", Rename: "kibana_memory_bytes", SetLabels: map[string]string{"area": "rss"}},
+		// the same series as the heap one, dropped
+		{Metric: "kibana_os_memory_used_in_bytes", Rename: "kibana_memory_bytes", SetLabels: map[string]string{"area": "heap"}},
+	})
+	if err != nil {
+		t.Fatalf("NewFilteredGatherer failed with valid input: %s", err)
+	}
+
+	expected := `
+# HELP kibana_memory_bytes Kibana heap used
+# TYPE kibana_memory_bytes gauge
+kibana_memory_bytes{area="heap"} 10
+kibana_memory_bytes{area="rss"} 20
+`
+
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected merged output: %s", err)
+	}
+}
+
+func TestParseRenames(t *testing.T) {
+	rules, err := ParseRenames([]string{"kibana_millis_uptime=kibana_uptime_ms"})
+	if err != nil {
+		t.Fatalf("ParseRenames failed with valid input: %s", err)
+	}
+
+	if len(rules) != 1 || rules[0].Metric != "kibana_millis_uptime" || rules[0].Rename != "kibana_uptime_ms" {
+		t.Errorf("unexpected rules %v", rules)
+	}
+
+	for _, pair := range []string{"kibana_millis_uptime", "=kibana_uptime_ms", "kibana_millis_uptime="} {
+		if _, err := ParseRenames([]string{pair}); err == nil {
+			t.Errorf("expected error for rename %q", pair)
+		}
+	}
+}
+
+func TestFilteredGathererAllMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":{"overall":{"level":"available"}}}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewExporter("kibana", collector, WithCollectors([]string{"status"}))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	// registered outside the exporter, like the probe metrics
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "kibana_probe_failures_total", Help: "Kibana synthetic probe failure count"})
+
+	// the pedantic registry fails if the exporter does not describe the
+	// metrics it collects
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e, failures)
+
+	g, err := NewFilteredGatherer(
+		reg,
+		[]string{"kibana_status", "kibana_core_.*", "kibana_probe_.*"},
+		[]string{"kibana_core_savedobjects_status", "kibana_probe_failures_total"},
+		[]RelabelRule{{Metric: "kibana_core_es_status", Rename: "kibana_elasticsearch_status"}},
+	)
+	if err != nil {
+		t.Fatalf("NewFilteredGatherer failed with valid input: %s", err)
+	}
+
+	expected := `
+# HELP kibana_elasticsearch_status Kibana Elasticsearch connectivity status
+# TYPE kibana_elasticsearch_status gauge
+kibana_elasticsearch_status 0
+# HELP kibana_status Kibana overall status
+# TYPE kibana_status gauge
+kibana_status 1
+`
+
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected filtered output: %s", err)
+	}
+}
+
+func TestNewFilteredGathererNothingToFilter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if g, err := NewFilteredGatherer(reg, nil, nil, nil); err != nil || g != reg {
+		t.Errorf("expected the gatherer to be returned as is, got %v and %v", g, err)
+	}
+}
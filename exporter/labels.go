@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// MetricsConfig is used to unmarshal the metrics section of the config
+// file, which applies to all the exported metrics.
+type MetricsConfig struct {
+	// Namespace is the prefix of all the metric names, kibana by default
+	Namespace string `yaml:"namespace"`
+
+	// ConstLabels are added to every exported series, ex: cluster: prod
+	ConstLabels map[string]string `yaml:"const_labels"`
+
+	// Include and Exclude are regular expressions matched against the
+	// metric names, before the relabel rules are applied
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	// Relabel rules are applied in order to the exported metrics
+	Relabel []RelabelRule `yaml:"relabel"`
+}
+
+// ParseConstLabels parses a list of key=value pairs into labels. Later
+// pairs override earlier ones with the same key.
+func ParseConstLabels(pairs []string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("const label %q should be in the key=value format", pair)
+		}
+
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	if err := ValidateConstLabels(labels); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// ValidateConstLabels checks the label names against the Prometheus
+// rules. Names starting with __ are reserved for internal use, le and
+// quantile are reserved for the histograms and summaries, and empty
+// values are rejected since they are the same as not having the label.
+// Names already used as labels by the exported metrics, ex: service or
+// level, are rejected as well.
+func ValidateConstLabels(labels prometheus.Labels) error {
+	names := make([]string, 0, len(labels))
+	for name, value := range labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid const label name %q", name)
+		}
+
+		if name == model.BucketLabel || name == model.QuantileLabel {
+			return fmt.Errorf("const label %s is reserved for histograms and summaries, choose another name", name)
+		}
+
+		if value == "" {
+			return fmt.Errorf("const label %s cannot have an empty value", name)
+		}
+
+		names = append(names, name)
+	}
+
+	// map iteration order is random, the first clash reported should be
+	// stable
+	sort.Strings(names)
+	for _, name := range names {
+		clashes, err := labelClashes(prometheus.Labels{name: labels[name]})
+		if err != nil {
+			return fmt.Errorf("error while checking const label %s: %s", name, err)
+		}
+
+		if clashes {
+			return fmt.Errorf("const label %s is also a label of the exported metrics, choose another name", name)
+		}
+	}
+
+	return nil
+}
+
+// labelClashes returns whether one of the const labels is also a label of
+// the metrics the exporter can export, whatever the collectors and the
+// naming scheme. The descriptors of such metrics are rejected by the
+// registry.
+func labelClashes(constLabels prometheus.Labels) (bool, error) {
+	all, err := allMetrics(collectorSettings{
+		namespace:         "kibana",
+		constLabels:       constLabels,
+		reportingInterval: defaultReportingInterval,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = prometheus.NewRegistry().Register(all)
+
+	return err != nil, nil
+}
+
+// validNamespace checks whether the namespace can be used as the prefix
+// of a metric name.
+func validNamespace(namespace string) bool {
+	return model.IsValidMetricName(model.LabelValue(namespace))
+}
@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseConstLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		pairs   []string
+		want    prometheus.Labels
+		wantErr bool
+	}{
+		{
+			name:  "none",
+			pairs: nil,
+			want:  prometheus.Labels{},
+		},
+		{
+			name:  "multiple",
+			pairs: []string{"cluster=prod", "region = eu-west-1"},
+			want:  prometheus.Labels{"cluster": "prod", "region": "eu-west-1"},
+		},
+		{
+			name:  "value with equals sign",
+			pairs: []string{"selector=a=b"},
+			want:  prometheus.Labels{"selector": "a=b"},
+		},
+		{
+			name:  "later pair overrides",
+			pairs: []string{"cluster=prod", "cluster=staging"},
+			want:  prometheus.Labels{"cluster": "staging"},
+		},
+		{
+			name:    "missing value",
+			pairs:   []string{"cluster"},
+			wantErr: true,
+		},
+		{
+			name:    "empty value",
+			pairs:   []string{"cluster="},
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			pairs:   []string{"kibana-cluster=prod"},
+			wantErr: true,
+		},
+		{
+			name:    "label of the status metrics",
+			pairs:   []string{"service=kibana"},
+			wantErr: true,
+		},
+		{
+			name:    "label of the probe metrics",
+			pairs:   []string{"endpoint=login"},
+			wantErr: true,
+		},
+		{
+			name:    "label of the remote write metrics",
+			pairs:   []string{"reason=rejected"},
+			wantErr: true,
+		},
+		{
+			name:    "reserved name",
+			pairs:   []string{"__name__=prod"},
+			wantErr: true,
+		},
+		{
+			name:    "reserved prefix",
+			pairs:   []string{"__cluster=prod"},
+			wantErr: true,
+		},
+		{
+			name:    "histogram bucket label",
+			pairs:   []string{"le=x"},
+			wantErr: true,
+		},
+		{
+			name:    "summary quantile label",
+			pairs:   []string{"quantile=x"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseConstLabels(tt.pairs)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got labels %v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected labels %v, got %v", tt.want, got)
+			}
+
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("expected label %s=%s, got %q", name, value, got[name])
+				}
+			}
+		})
+	}
+}
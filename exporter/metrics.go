@@ -0,0 +1,314 @@
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// NamingLegacy exports the metric names used by the exporter before
+	// the standard names were introduced
+	NamingLegacy = "legacy"
+
+	// NamingStandard exports metric names that follow the Prometheus
+	// naming conventions, with values in base units
+	NamingStandard = "standard"
+
+	// NamingBoth exports both the legacy and the standard names, to help
+	// with migrating dashboards and alerts
+	NamingBoth = "both"
+
+	millisToSeconds = 0.001
+)
+
+func init() {
+	registerCollector("os", "Kibana host load and memory usage", true, newGaugesCollector("os"))
+	registerCollector("http", "Kibana HTTP connections, requests, and response times", true, newGaugesCollector("http"))
+}
+
+// metricDefinition describes a gauge that is set from the Kibana status.
+type metricDefinition struct {
+	// collector is the name of the collector exporting the gauge
+	collector string
+
+	legacyName string
+	legacyHelp string
+
+	standardName string
+	standardHelp string
+
+	// value returns the value as reported by Kibana, which is what the
+	// legacy name exports
+	value func(m *KibanaMetrics) float64
+
+	// scale converts the value to the base unit of the standard name
+	scale float64
+}
+
+var (
+	// metricDefinitions is the list of gauges set from the Kibana status,
+	// except for the status levels which have their own encoding
+	metricDefinitions = []metricDefinition{
+		{
+			collector:    "http",
+			legacyName:   "concurrent_connections",
+			legacyHelp:   "Kibana Concurrent Connections",
+			standardName: "http_concurrent_connections",
+			standardHelp: "Kibana concurrent HTTP connections",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.ConcurrentConnections) },
+			scale:        1,
+		},
+		{
+			collector:    "process",
+			legacyName:   "millis_uptime",
+			legacyHelp:   "Kibana uptime in milliseconds",
+			standardName: "process_uptime_seconds",
+			standardHelp: "Kibana process uptime in seconds",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.Process.UptimeInMillis },
+			scale:        millisToSeconds,
+		},
+		{
+			collector:    "process",
+			legacyName:   "heap_max_in_bytes",
+			legacyHelp:   "Kibana process Heap maximum in bytes",
+			standardName: "process_heap_total_bytes",
+			standardHelp: "Kibana process heap size in bytes",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Process.Memory.Heap.TotalInBytes) },
+			scale:        1,
+		},
+		{
+			collector:    "process",
+			legacyName:   "heap_used_in_bytes",
+			legacyHelp:   "Kibana process Heap usage in bytes",
+			standardName: "process_heap_used_bytes",
+			standardHelp: "Kibana process heap usage in bytes",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Process.Memory.Heap.UsedInBytes) },
+			scale:        1,
+		},
+		{
+			collector:    "process",
+			legacyName:   "resident_set_size_in_bytes",
+			legacyHelp:   "Kibana Memory Resident Set Size in bytes",
+			standardName: "process_resident_memory_bytes",
+			standardHelp: "Kibana process resident memory size in bytes",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Process.Memory.ResidentSetSizeInBytes) },
+			scale:        1,
+		},
+		{
+			collector:    "process",
+			legacyName:   "event_loop_delay",
+			legacyHelp:   "Kibana NodeJS Event Loop Delay in milliseconds",
+			standardName: "process_event_loop_delay_seconds",
+			standardHelp: "Kibana NodeJS event loop delay in seconds",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.Process.EventLoopDelayInMillis },
+			scale:        millisToSeconds,
+		},
+		{
+			collector:    "os",
+			legacyName:   "os_load_1m",
+			legacyHelp:   "Kibana load average 1m",
+			standardName: "os_load1",
+			standardHelp: "Kibana host 1m load average",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.Os.Load.Load1m },
+			scale:        1,
+		},
+		{
+			collector:    "os",
+			legacyName:   "os_load_5m",
+			legacyHelp:   "Kibana load average 5m",
+			standardName: "os_load5",
+			standardHelp: "Kibana host 5m load average",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.Os.Load.Load5m },
+			scale:        1,
+		},
+		{
+			collector:    "os",
+			legacyName:   "os_load_15m",
+			legacyHelp:   "Kibana load average 15m",
+			standardName: "os_load15",
+			standardHelp: "Kibana host 15m load average",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.Os.Load.Load15m },
+			scale:        1,
+		},
+		{
+			collector:    "os",
+			legacyName:   "os_memory_max_in_bytes",
+			legacyHelp:   "Kibana memory maximum in bytes",
+			standardName: "os_memory_total_bytes",
+			standardHelp: "Kibana host memory size in bytes",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Os.Memory.TotalInBytes) },
+			scale:        1,
+		},
+		{
+			collector:    "os",
+			legacyName:   "os_memory_used_in_bytes",
+			legacyHelp:   "Kibana memory used in bytes",
+			standardName: "os_memory_used_bytes",
+			standardHelp: "Kibana host memory usage in bytes",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Os.Memory.UsedInBytes) },
+			scale:        1,
+		},
+		{
+			collector:    "http",
+			legacyName:   "response_average",
+			legacyHelp:   "Kibana average response time in milliseconds",
+			standardName: "http_response_time_avg_seconds",
+			standardHelp: "Kibana average HTTP response time in seconds",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.ResponseTimes.AvgInMillis },
+			scale:        millisToSeconds,
+		},
+		{
+			collector:    "http",
+			legacyName:   "response_max",
+			legacyHelp:   "Kibana maximum response time in milliseconds",
+			standardName: "http_response_time_max_seconds",
+			standardHelp: "Kibana maximum HTTP response time in seconds",
+			value:        func(m *KibanaMetrics) float64 { return m.Metrics.ResponseTimes.MaxInMillis },
+			scale:        millisToSeconds,
+		},
+		{
+			collector:  "http",
+			legacyName: "requests_disconnects",
+			legacyHelp: "Kibana request disconnections count",
+			// Kibana resets the request counts on every metrics
+			// collection interval, so these are not counters
+			standardName: "http_request_disconnects",
+			standardHelp: "Kibana HTTP request disconnections in the last metrics collection interval",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Requests.Disconnects) },
+			scale:        1,
+		},
+		{
+			collector:    "http",
+			legacyName:   "requests_total",
+			legacyHelp:   "Kibana total request count",
+			standardName: "http_requests",
+			standardHelp: "Kibana HTTP requests in the last metrics collection interval",
+			value:        func(m *KibanaMetrics) float64 { return float64(m.Metrics.Requests.Total) },
+			scale:        1,
+		},
+	}
+)
+
+// definedGauge is a gauge built from a metricDefinition for one of the
+// naming schemes.
+type definedGauge struct {
+	gauge prometheus.Gauge
+	value func(m *KibanaMetrics) float64
+	scale float64
+}
+
+func (g *definedGauge) set(m *KibanaMetrics) {
+	g.gauge.Set(g.value(m) * g.scale)
+}
+
+// checkNaming returns an error if the naming scheme is unknown.
+func checkNaming(naming string) error {
+	switch naming {
+	case NamingLegacy, NamingStandard, NamingBoth:
+		return nil
+	default:
+		return fmt.Errorf("unknown metric naming scheme %q, should be one of %s, %s, or %s", naming, NamingLegacy, NamingStandard, NamingBoth)
+	}
+}
+
+// definitionsFor returns the definitions in metricDefinitions that belong
+// to the given collector.
+func definitionsFor(collector string) []metricDefinition {
+	var definitions []metricDefinition
+	for _, def := range metricDefinitions {
+		if def.collector == collector {
+			definitions = append(definitions, def)
+		}
+	}
+
+	return definitions
+}
+
+// newDefinedGauges builds the gauges of the given definitions for the
+// given naming scheme.
+func newDefinedGauges(namespace, naming string, constLabels prometheus.Labels, definitions []metricDefinition) ([]*definedGauge, error) {
+	if err := checkNaming(naming); err != nil {
+		return nil, err
+	}
+
+	legacy := naming == NamingLegacy || naming == NamingBoth
+	standard := naming == NamingStandard || naming == NamingBoth
+
+	var gauges []*definedGauge
+	for _, def := range definitions {
+		if legacy {
+			gauges = append(gauges, &definedGauge{
+				gauge: prometheus.NewGauge(
+					prometheus.GaugeOpts{
+						Name:        def.legacyName,
+						Namespace:   namespace,
+						ConstLabels: constLabels,
+						Help:        def.legacyHelp,
+					}),
+				value: def.value,
+				scale: 1,
+			})
+		}
+
+		if standard {
+			gauges = append(gauges, &definedGauge{
+				gauge: prometheus.NewGauge(
+					prometheus.GaugeOpts{
+						Name:        def.standardName,
+						Namespace:   namespace,
+						ConstLabels: constLabels,
+						Help:        def.standardHelp,
+					}),
+				value: def.value,
+				scale: def.scale,
+			})
+		}
+	}
+
+	return gauges, nil
+}
+
+// gaugesCollector exports the gauges in metricDefinitions that belong to
+// a collector.
+type gaugesCollector struct {
+	gauges []*definedGauge
+}
+
+// newGaugesCollector returns the factory of the collector with the given
+// name, exporting its gauges in metricDefinitions.
+func newGaugesCollector(name string) collectorFactory {
+	return func(s *collectorSettings) (subCollector, error) {
+		return newGaugesCollectorFor(name, s)
+	}
+}
+
+func newGaugesCollectorFor(name string, s *collectorSettings) (*gaugesCollector, error) {
+	gauges, err := newDefinedGauges(s.namespace, s.naming, s.constLabels, definitionsFor(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &gaugesCollector{gauges: gauges}, nil
+}
+
+// Describe is the gaugesCollector implementing subCollector
+func (c *gaugesCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, g := range c.gauges {
+		ch <- g.gauge.Desc()
+	}
+}
+
+func (c *gaugesCollector) update(s *scrape, ch chan<- prometheus.Metric) error {
+	m, err := s.status()
+	if err != nil {
+		return err
+	}
+
+	for _, g := range c.gauges {
+		g.set(m)
+		ch <- g.gauge
+	}
+
+	return nil
+}
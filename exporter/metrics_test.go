@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewExporterInvalidNaming(t *testing.T) {
+	_, err := NewExporter("kibana", &KibanaCollector{}, WithNaming("camel"))
+	if err == nil {
+		t.Errorf("expected error when an unknown naming scheme was provided")
+	}
+}
+
+var namingTests = []struct {
+	naming string
+	count  int
+}{
+	{naming: NamingLegacy, count: len(metricDefinitions)},
+	{naming: NamingStandard, count: len(metricDefinitions)},
+	{naming: NamingBoth, count: 2 * len(metricDefinitions)},
+}
+
+func TestNewDefinedGauges(t *testing.T) {
+	for _, nt := range namingTests {
+		t.Run(nt.naming, func(t *testing.T) {
+			gauges, err := newDefinedGauges("kibana", nt.naming, nil, metricDefinitions)
+			if err != nil {
+				t.Fatalf("newDefinedGauges failed with valid input: %s", err)
+			}
+
+			if len(gauges) != nt.count {
+				t.Errorf("expected %d gauges, got %d", nt.count, len(gauges))
+			}
+		})
+	}
+}
+
+func TestDefinedGaugesBaseUnits(t *testing.T) {
+	gauges, err := newDefinedGauges("kibana", NamingBoth, nil, metricDefinitions)
+	if err != nil {
+		t.Fatalf("newDefinedGauges failed with valid input: %s", err)
+	}
+
+	m := &KibanaMetrics{}
+	m.Metrics.Process.UptimeInMillis = 1500
+
+	for _, g := range gauges {
+		g.set(m)
+	}
+
+	expected := `
+# HELP kibana_millis_uptime Kibana uptime in milliseconds
+# TYPE kibana_millis_uptime gauge
+kibana_millis_uptime 1500
+# HELP kibana_process_uptime_seconds Kibana process uptime in seconds
+# TYPE kibana_process_uptime_seconds gauge
+kibana_process_uptime_seconds 1.5
+`
+
+	reg := prometheus.NewPedanticRegistry()
+	for _, g := range gauges {
+		reg.MustRegister(g.gauge)
+	}
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "kibana_millis_uptime", "kibana_process_uptime_seconds")
+	if err != nil {
+		t.Errorf("unexpected uptime output: %s", err)
+	}
+}
+
+func TestDefinitionsFor(t *testing.T) {
+	count := 0
+	for _, name := range []string{"process", "os", "http"} {
+		definitions := definitionsFor(name)
+		if len(definitions) == 0 {
+			t.Errorf("expected definitions for the %s collector", name)
+		}
+
+		if _, ok := collectorRegistrations[name]; !ok {
+			t.Errorf("expected the %s collector to be registered", name)
+		}
+
+		count += len(definitions)
+	}
+
+	if count != len(metricDefinitions) {
+		t.Errorf("expected all %d definitions to belong to a collector, got %d", len(metricDefinitions), count)
+	}
+}
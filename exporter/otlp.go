@@ -0,0 +1,572 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// OTLPProtocolGRPC and OTLPProtocolHTTP are the supported OTLP
+	// transports
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+
+	defaultOTLPInterval    = time.Minute
+	defaultOTLPTimeout     = 10 * time.Second
+	defaultOTLPServiceName = "kibana"
+
+	// otlpHTTPPath is the default path of the OTLP/HTTP metrics endpoint
+	otlpHTTPPath = "/v1/metrics"
+
+	// otlpScopeName is the instrumentation scope of the exported metrics
+	otlpScopeName = "github.com/chamilad/kibana-prometheus-exporter"
+
+	otlpUserAgent = "kibana-prometheus-exporter"
+)
+
+// OTLPConfig describes the OpenTelemetry collector to export the metrics
+// to with OTLP.
+type OTLPConfig struct {
+	// Endpoint is the collector URL, ex: http://otel-collector:4318 for
+	// http/protobuf or http://otel-collector:4317 for grpc, a plain text
+	// connection is used for http URLs, OTLP export is disabled if empty.
+	// The /v1/metrics path is added for http/protobuf if there is none.
+	Endpoint string `yaml:"endpoint"`
+
+	// Protocol is either grpc or http/protobuf, defaults to http/protobuf
+	Protocol string `yaml:"protocol"`
+
+	// Interval is the time between two exports, defaults to 1m
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is the maximum time a single export can take, defaults to
+	// 10s
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Headers are added to every request, ex: an API key of the collector
+	Headers map[string]string `yaml:"headers"`
+
+	// ResourceAttributes are added to the resource attributes, and can
+	// override the service.name, service.version and service.instance.id
+	// attributes set from the Kibana status
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+
+	TLS ClientTLSConfig `yaml:"tls"`
+}
+
+// OTLPExporter periodically gathers the metrics from a registry and
+// exports them to an OpenTelemetry collector as OTLP gauges, sums,
+// histograms and summaries, with the Kibana service as the resource.
+type OTLPExporter struct {
+	gatherer  prometheus.Gatherer
+	collector *KibanaCollector
+	endpoint  string
+	protocol  string
+	interval  time.Duration
+	timeout   time.Duration
+	headers   map[string]string
+	resource  map[string]string
+
+	// client sends the OTLP/HTTP requests
+	client *http.Client
+
+	// conn and service make the OTLP/gRPC calls, nil for http/protobuf
+	conn    *grpc.ClientConn
+	service colmetricspb.MetricsServiceClient
+
+	// start is the start time of the cumulative sums, histograms and
+	// summaries
+	start time.Time
+}
+
+// NewOTLPExporter will validate the OTLP configuration and create an
+// OTLPExporter struct that exports the metrics of the given gatherer. The
+// resource attributes are taken from the last status of the given
+// collector. The metrics are converted from the gathered Prometheus
+// metrics rather than from the Kibana status, so that the other
+// collectors, the custom metrics and the filters are also exported.
+func NewOTLPExporter(gatherer prometheus.Gatherer, collector *KibanaCollector, config OTLPConfig) (*OTLPExporter, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, should be an http or https URL", config.Endpoint)
+	}
+
+	if config.Protocol == "" {
+		config.Protocol = OTLPProtocolHTTP
+	}
+
+	if config.Interval == 0 {
+		config.Interval = defaultOTLPInterval
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultOTLPTimeout
+	}
+
+	if config.Interval < 0 || config.Timeout < 0 {
+		return nil, errors.New("OTLP interval and timeout cannot be negative")
+	}
+
+	for name, value := range config.ResourceAttributes {
+		if name == "" || value == "" {
+			return nil, fmt.Errorf("invalid OTLP resource attribute %s=%q", name, value)
+		}
+	}
+
+	tlsConfig, err := config.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil && u.Scheme != "https" {
+		log.Info().
+			Msgf("OTLP TLS settings are ignored for a plain text endpoint: %s", config.Endpoint)
+	}
+
+	o := &OTLPExporter{
+		gatherer:  gatherer,
+		collector: collector,
+		protocol:  config.Protocol,
+		interval:  config.Interval,
+		timeout:   config.Timeout,
+		headers:   config.Headers,
+		resource:  config.ResourceAttributes,
+		start:     time.Now(),
+	}
+
+	switch config.Protocol {
+	case OTLPProtocolHTTP:
+		if u.Path == "" || u.Path == "/" {
+			u.Path = otlpHTTPPath
+		}
+
+		o.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	case OTLPProtocolGRPC:
+		if u.Path != "" && u.Path != "/" {
+			return nil, fmt.Errorf("invalid OTLP endpoint %q, should not have a path for grpc", config.Endpoint)
+		}
+
+		u.Path = ""
+		creds := insecure.NewCredentials()
+		if u.Scheme == "https" {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+
+		// the default ports of the URL scheme, as for http/protobuf
+		target := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+
+			target = net.JoinHostPort(u.Hostname(), port)
+		}
+
+		// connects on the first export, and reconnects as needed
+		conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds), grpc.WithUserAgent(otlpUserAgent))
+		if err != nil {
+			return nil, fmt.Errorf("error while creating the OTLP gRPC connection: %s", err)
+		}
+
+		o.conn = conn
+		o.service = colmetricspb.NewMetricsServiceClient(conn)
+	default:
+		return nil, fmt.Errorf("invalid OTLP protocol %q, should be %s or %s", config.Protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+
+	o.endpoint = u.String()
+
+	return o, nil
+}
+
+// Export will gather the metrics and export them once.
+func (o *OTLPExporter) Export(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	start := time.Now()
+	families, err := o.gatherer.Gather()
+	if err != nil {
+		// partial results are still exported, as promhttp does
+		log.Warn().
+			Msgf("error while gathering metrics: %s", err)
+	}
+
+	req := otlpRequest(o.resourceAttributes(), families, o.start, start)
+
+	var partial *colmetricspb.ExportMetricsPartialSuccess
+	if o.service != nil {
+		partial, err = o.exportGRPC(ctx, req)
+	} else {
+		partial, err = o.exportHTTP(ctx, req)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error while exporting metrics to %s: %s", o.endpoint, err)
+	}
+
+	if partial.GetRejectedDataPoints() > 0 || partial.GetErrorMessage() != "" {
+		log.Warn().
+			Msgf("OTLP collector rejected %d data points: %s", partial.GetRejectedDataPoints(), partial.GetErrorMessage())
+	}
+
+	log.Debug().
+		Msgf("exported metrics to %s in %s", o.endpoint, time.Since(start))
+
+	return nil
+}
+
+// resourceAttributes returns the resource attributes. The service version
+// and instance id are taken from the last good Kibana status, and are
+// missing until Kibana responds.
+func (o *OTLPExporter) resourceAttributes() map[string]string {
+	attrs := map[string]string{"service.name": defaultOTLPServiceName}
+	if o.collector != nil {
+		if m := o.collector.lastStatus(); m != nil {
+			if m.Version.Number != "" {
+				attrs["service.version"] = m.Version.Number
+			}
+
+			if m.UUID != "" {
+				attrs["service.instance.id"] = m.UUID
+			}
+		}
+	}
+
+	for key, value := range o.resource {
+		attrs[key] = value
+	}
+
+	return attrs
+}
+
+// exportHTTP will send the request with OTLP/HTTP, and return the partial
+// success of the response, if any.
+func (o *OTLPExporter) exportHTTP(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsPartialSuccess, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while encoding the OTLP request: %s", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range o.headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", otlpUserAgent)
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OTLP collector responded with %d", resp.StatusCode)
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-protobuf") {
+		// not a protobuf response to check for partial success
+		return nil, nil
+	}
+
+	var exportResp colmetricspb.ExportMetricsServiceResponse
+	if err := proto.Unmarshal(content, &exportResp); err != nil {
+		log.Debug().
+			Msgf("could not decode the OTLP response: %s", err)
+		return nil, nil
+	}
+
+	return exportResp.GetPartialSuccess(), nil
+}
+
+// exportGRPC will call the Export method of the OTLP metrics service, and
+// return the partial success of the response, if any.
+func (o *OTLPExporter) exportGRPC(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsPartialSuccess, error) {
+	if len(o.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.headers))
+	}
+
+	resp, err := o.service.Export(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetPartialSuccess(), nil
+}
+
+// Run will export the metrics, once immediately and then on every
+// interval, until the context is cancelled.
+func (o *OTLPExporter) Run(ctx context.Context) {
+	log.Info().
+		Msgf("exporting metrics with OTLP %s to %s every %s", o.protocol, o.endpoint, o.interval)
+
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.Export(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().
+				Msgf("%s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Msg("stopping OTLP export")
+			o.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close will close the gRPC connection, if any.
+func (o *OTLPExporter) Close() {
+	if o.conn == nil {
+		return
+	}
+
+	if err := o.conn.Close(); err != nil {
+		log.Debug().
+			Msgf("error while closing the OTLP gRPC connection: %s", err)
+	}
+}
+
+// otlpAttributes returns the attributes as string attributes, sorted by
+// key.
+func otlpAttributes(attrs map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, stringAttribute(key, attrs[key]))
+	}
+
+	return kvs
+}
+
+// stringAttribute returns an attribute with a string value.
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key: key,
+		Value: &commonpb.AnyValue{
+			Value: &commonpb.AnyValue_StringValue{StringValue: value},
+		},
+	}
+}
+
+// metricAttributes returns the labels of the metric as attributes.
+func metricAttributes(m *dto.Metric) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		attrs = append(attrs, stringAttribute(lp.GetName(), lp.GetValue()))
+	}
+
+	return attrs
+}
+
+// numberPoint returns a data point with a double value, without a start
+// time if start is 0.
+func numberPoint(m *dto.Metric, value float64, start, now uint64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        metricAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+// histogramPoint returns a histogram data point, converting the
+// cumulative Prometheus buckets to the OTLP bucket counts.
+func histogramPoint(m *dto.Metric, start, now uint64) *metricspb.HistogramDataPoint {
+	h := m.GetHistogram()
+
+	var bounds []float64
+	var counts []uint64
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+
+		bounds = append(bounds, bucket.GetUpperBound())
+		counts = append(counts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+
+	// the last bucket has no upper bound
+	counts = append(counts, h.GetSampleCount()-previous)
+
+	return &metricspb.HistogramDataPoint{
+		Attributes:        metricAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             h.GetSampleCount(),
+		Sum:               proto.Float64(h.GetSampleSum()),
+		BucketCounts:      counts,
+		ExplicitBounds:    bounds,
+	}
+}
+
+// summaryPoint returns a summary data point.
+func summaryPoint(m *dto.Metric, start, now uint64) *metricspb.SummaryDataPoint {
+	s := m.GetSummary()
+
+	quantiles := make([]*metricspb.SummaryDataPoint_ValueAtQuantile, 0, len(s.GetQuantile()))
+	for _, q := range s.GetQuantile() {
+		quantiles = append(quantiles, &metricspb.SummaryDataPoint_ValueAtQuantile{
+			Quantile: q.GetQuantile(),
+			Value:    q.GetValue(),
+		})
+	}
+
+	return &metricspb.SummaryDataPoint{
+		Attributes:        metricAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             s.GetSampleCount(),
+		Sum:               s.GetSampleSum(),
+		QuantileValues:    quantiles,
+	}
+}
+
+// otlpMetric converts a metric family to an OTLP metric, counters as
+// monotonic cumulative sums, gauges and untyped metrics as gauges.
+// Returns nil for the families without metrics.
+func otlpMetric(mf *dto.MetricFamily, start, now time.Time) *metricspb.Metric {
+	if len(mf.GetMetric()) == 0 {
+		return nil
+	}
+
+	startNano, nowNano := uint64(start.UnixNano()), uint64(now.UnixNano())
+	pointTime := func(m *dto.Metric) uint64 {
+		if m.TimestampMs != nil {
+			return uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+		}
+
+		return nowNano
+	}
+
+	metric := &metricspb.Metric{
+		Name:        mf.GetName(),
+		Description: mf.GetHelp(),
+	}
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}
+
+		for _, m := range mf.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, numberPoint(m, m.GetCounter().GetValue(), startNano, pointTime(m)))
+		}
+
+		metric.Data = &metricspb.Metric_Sum{Sum: sum}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		gauge := &metricspb.Gauge{}
+		for _, m := range mf.GetMetric() {
+			value := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				value = m.GetUntyped().GetValue()
+			}
+
+			gauge.DataPoints = append(gauge.DataPoints, numberPoint(m, value, 0, pointTime(m)))
+		}
+
+		metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+	case dto.MetricType_HISTOGRAM:
+		histogram := &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}
+
+		for _, m := range mf.GetMetric() {
+			histogram.DataPoints = append(histogram.DataPoints, histogramPoint(m, startNano, pointTime(m)))
+		}
+
+		metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+	case dto.MetricType_SUMMARY:
+		summary := &metricspb.Summary{}
+		for _, m := range mf.GetMetric() {
+			summary.DataPoints = append(summary.DataPoints, summaryPoint(m, startNano, pointTime(m)))
+		}
+
+		metric.Data = &metricspb.Metric_Summary{Summary: summary}
+	default:
+		return nil
+	}
+
+	return metric
+}
+
+// otlpRequest converts the metric families to an export request with a
+// single resource and scope.
+func otlpRequest(resource map[string]string, families []*dto.MetricFamily, start, now time.Time) *colmetricspb.ExportMetricsServiceRequest {
+	metrics := make([]*metricspb.Metric, 0, len(families))
+	for _, mf := range families {
+		if metric := otlpMetric(mf, start, now); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: &resourcepb.Resource{Attributes: otlpAttributes(resource)},
+				ScopeMetrics: []*metricspb.ScopeMetrics{
+					{
+						Scope:   &commonpb.InstrumentationScope{Name: otlpScopeName},
+						Metrics: metrics,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,348 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpResource returns the resource attributes of the request.
+func otlpResource(req *colmetricspb.ExportMetricsServiceRequest) map[string]string {
+	attrs := map[string]string{}
+	for _, rm := range req.GetResourceMetrics() {
+		for _, kv := range rm.GetResource().GetAttributes() {
+			attrs[kv.GetKey()] = kv.GetValue().GetStringValue()
+		}
+	}
+
+	return attrs
+}
+
+// otlpMetrics returns the metrics of the request by name.
+func otlpMetrics(req *colmetricspb.ExportMetricsServiceRequest) map[string]*metricspb.Metric {
+	metrics := map[string]*metricspb.Metric{}
+	for _, rm := range req.GetResourceMetrics() {
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				metrics[m.GetName()] = m
+			}
+		}
+	}
+
+	return metrics
+}
+
+// otlpTestService is an OTLP metrics service that keeps the last request
+// and its metadata, and responds with the given error.
+type otlpTestService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	lock     sync.Mutex
+	request  *colmetricspb.ExportMetricsServiceRequest
+	metadata metadata.MD
+	err      error
+}
+
+func (s *otlpTestService) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.request = req
+	s.metadata, _ = metadata.FromIncomingContext(ctx)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+// startOTLPTestService serves the service over gRPC, and returns its
+// address.
+func startOTLPTestService(t *testing.T, service *otlpTestService, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	server := grpc.NewServer(opts...)
+	colmetricspb.RegisterMetricsServiceServer(server, service)
+	go func() {
+		_ = server.Serve(l)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	return l.Addr().String()
+}
+
+// otlpTestCollector returns a collector that received a status from a
+// Kibana with a UUID and a version.
+func otlpTestCollector(t *testing.T) *KibanaCollector {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name":"kibana","uuid":"5b2de169-2785-441b-ae8c-186a1936b17d","version":{"number":"8.7.1"},"status":{"overall":{"level":"available"}}}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	if _, err := collector.scrape(context.Background()); err != nil {
+		t.Fatalf("unexpected scrape error: %s", err)
+	}
+
+	return collector
+}
+
+func TestNewOTLPExporterInvalid(t *testing.T) {
+	configs := map[string]OTLPConfig{
+		"no endpoint":        {},
+		"no scheme":          {Endpoint: "otel-collector:4317"},
+		"unknown protocol":   {Endpoint: "http://otel-collector:4317", Protocol: "http/json"},
+		"grpc path":          {Endpoint: "http://otel-collector:4317/v1/metrics", Protocol: OTLPProtocolGRPC},
+		"negative interval":  {Endpoint: "http://otel-collector:4318", Interval: -time.Second},
+		"empty attribute":    {Endpoint: "http://otel-collector:4318", ResourceAttributes: map[string]string{"deployment.environment": ""}},
+		"missing CA file":    {Endpoint: "https://otel-collector:4318", TLS: ClientTLSConfig{CAFile: "does-not-exist.crt"}},
+		"missing client key": {Endpoint: "https://otel-collector:4318", TLS: ClientTLSConfig{CertFile: "client.crt"}},
+	}
+
+	for desc, config := range configs {
+		if _, err := NewOTLPExporter(prometheus.NewRegistry(), nil, config); err == nil {
+			t.Errorf("expected an error for %s", desc)
+		}
+	}
+}
+
+func TestOTLPExporterHTTP(t *testing.T) {
+	var path, contentType, apiKey string
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		apiKey = r.Header.Get("Api-Key")
+		body, _ = io.ReadAll(r.Body)
+
+		resp, _ := proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{
+			PartialSuccess: &colmetricspb.ExportMetricsPartialSuccess{
+				RejectedDataPoints: 1,
+				ErrorMessage:       "invalid metric name",
+			},
+		})
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(resp)
+	}))
+	defer ts.Close()
+
+	reg := remoteWriteTestRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kibana_requests_total",
+		Help: "Kibana request count",
+	})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "kibana_response_time_seconds",
+		Help:       "Kibana response time",
+		Objectives: map[float64]float64{0.5: 0.05},
+	})
+	summary.Observe(0.2)
+	reg.MustRegister(counter, summary)
+
+	o, err := NewOTLPExporter(reg, otlpTestCollector(t), OTLPConfig{
+		Endpoint:           ts.URL,
+		Headers:            map[string]string{"Api-Key": "secret"},
+		ResourceAttributes: map[string]string{"deployment.environment": "production"},
+	})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed with valid input: %s", err)
+	}
+
+	// a partial success is not an error
+	if err := o.Export(context.Background()); err != nil {
+		t.Fatalf("unexpected export error: %s", err)
+	}
+
+	if path != "/v1/metrics" || contentType != "application/x-protobuf" || apiKey != "secret" {
+		t.Errorf("unexpected OTLP/HTTP request to %s with %s and api key %q", path, contentType, apiKey)
+	}
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		t.Fatalf("invalid ExportMetricsServiceRequest: %s", err)
+	}
+
+	resource := otlpResource(&req)
+	expectedResource := map[string]string{
+		"service.name":           "kibana",
+		"service.version":        "8.7.1",
+		"service.instance.id":    "5b2de169-2785-441b-ae8c-186a1936b17d",
+		"deployment.environment": "production",
+	}
+
+	if len(resource) != len(expectedResource) {
+		t.Errorf("expected resource attributes %v, got %v", expectedResource, resource)
+	}
+
+	for key, value := range expectedResource {
+		if resource[key] != value {
+			t.Errorf("expected resource attribute %s=%s, got %q", key, value, resource[key])
+		}
+	}
+
+	metrics := otlpMetrics(&req)
+	if len(metrics) != 4 {
+		t.Errorf("expected 4 metrics, got %d", len(metrics))
+	}
+
+	if metrics["kibana_up"].GetGauge() == nil {
+		t.Errorf("expected kibana_up as a gauge")
+	}
+
+	if sum := metrics["kibana_requests_total"].GetSum(); sum == nil || !sum.GetIsMonotonic() ||
+		sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Errorf("expected kibana_requests_total as a monotonic cumulative sum")
+	}
+
+	if metrics["kibana_probe_duration_seconds"].GetHistogram() == nil {
+		t.Errorf("expected kibana_probe_duration_seconds as a histogram")
+	}
+
+	points := metrics["kibana_response_time_seconds"].GetSummary().GetDataPoints()
+	if len(points) != 1 || points[0].GetCount() != 1 || len(points[0].GetQuantileValues()) != 1 {
+		t.Errorf("expected kibana_response_time_seconds as a summary, got %v", points)
+	}
+}
+
+func TestOTLPExporterHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	o, err := NewOTLPExporter(pushTestRegistry(), nil, OTLPConfig{Endpoint: ts.URL})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed with valid input: %s", err)
+	}
+
+	if err := o.Export(context.Background()); err == nil {
+		t.Errorf("expected an error for a 503 response")
+	}
+}
+
+func TestOTLPExporterGRPC(t *testing.T) {
+	// only used for its certificate, valid for 127.0.0.1
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatalf("could not write CA file: %s", err)
+	}
+
+	service := &otlpTestService{}
+	addr := startOTLPTestService(t, service, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: ts.TLS.Certificates})))
+
+	o, err := NewOTLPExporter(pushTestRegistry(), nil, OTLPConfig{
+		Endpoint: "https://" + addr,
+		Protocol: OTLPProtocolGRPC,
+		Headers:  map[string]string{"Api-Key": "secret"},
+		TLS:      ClientTLSConfig{CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed with valid input: %s", err)
+	}
+	defer o.Close()
+
+	if err := o.Export(context.Background()); err != nil {
+		t.Fatalf("unexpected export error: %s", err)
+	}
+
+	service.lock.Lock()
+	if otlpResource(service.request)["service.name"] != "kibana" || otlpMetrics(service.request)["kibana_up"].GetGauge() == nil {
+		t.Errorf("unexpected gRPC request, got %v", service.request)
+	}
+
+	if key := service.metadata.Get("api-key"); len(key) != 1 || key[0] != "secret" {
+		t.Errorf("expected the headers as gRPC metadata, got %v", service.metadata)
+	}
+
+	service.err = status.Error(codes.Unavailable, "collector is overloaded")
+	service.lock.Unlock()
+
+	err = o.Export(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "collector is overloaded") {
+		t.Errorf("expected the gRPC status message in the error, got %v", err)
+	}
+}
+
+func TestOTLPExporterGRPCPlainText(t *testing.T) {
+	service := &otlpTestService{}
+	addr := startOTLPTestService(t, service)
+
+	o, err := NewOTLPExporter(pushTestRegistry(), nil, OTLPConfig{Endpoint: "http://" + addr, Protocol: OTLPProtocolGRPC})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed with valid input: %s", err)
+	}
+	defer o.Close()
+
+	if err := o.Export(context.Background()); err != nil {
+		t.Fatalf("unexpected export error: %s", err)
+	}
+
+	if o.endpoint != "http://"+addr {
+		t.Errorf("expected the endpoint without a path, got %s", o.endpoint)
+	}
+}
+
+func TestOTLPMetricHistogram(t *testing.T) {
+	reg := remoteWriteTestRegistry()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected gather error: %s", err)
+	}
+
+	// the histogram is sorted before kibana_up
+	points := otlpMetric(families[0], time.Now(), time.Now()).GetHistogram().GetDataPoints()
+	if len(points) != 1 {
+		t.Fatalf("expected a single histogram data point, got %v", points)
+	}
+
+	if bounds := points[0].GetExplicitBounds(); len(bounds) != 1 || bounds[0] != 0.5 {
+		t.Errorf("expected the 0.5 bucket bound, got %v", bounds)
+	}
+
+	if counts := points[0].GetBucketCounts(); len(counts) != 2 || counts[0] != 1 || counts[1] != 0 {
+		t.Errorf("expected the bucket counts [1 0], got %v", counts)
+	}
+
+	if points[0].GetCount() != 1 || points[0].GetSum() != 0.1 {
+		t.Errorf("expected a count of 1 and a sum of 0.1, got %d and %f", points[0].GetCount(), points[0].GetSum())
+	}
+}
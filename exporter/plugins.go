@@ -0,0 +1,57 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	// disabled by default since Kibana reports the status of more than
+	// a hundred plugins
+	registerCollector("plugins", "Kibana plugin status levels", false, newPluginsCollector)
+}
+
+// pluginsCollector exports the status levels of the Kibana plugins.
+type pluginsCollector struct {
+	// metrics
+	statusLevel *prometheus.GaugeVec
+}
+
+func newPluginsCollector(s *collectorSettings) (subCollector, error) {
+	return &pluginsCollector{
+		statusLevel: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "plugin_status_level",
+				Help:        "Kibana plugin status level by plugin, 1 for the current level and 0 for the others",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			},
+			[]string{"plugin", "level"}),
+	}, nil
+}
+
+// parseMetrics will set the plugin status levels using the KibanaMetrics
+// struct. Plugins that are no longer reported are dropped.
+func (c *pluginsCollector) parseMetrics(m *KibanaMetrics) {
+	c.statusLevel.Reset()
+	for plugin, status := range m.Status.Plugins {
+		level, _ := normalizeStatusLevel(status.Level)
+		setStatusLevel(c.statusLevel, plugin, level)
+	}
+}
+
+// Describe is the pluginsCollector implementing subCollector
+func (c *pluginsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.statusLevel.Describe(ch)
+}
+
+func (c *pluginsCollector) update(s *scrape, ch chan<- prometheus.Metric) error {
+	m, err := s.status()
+	if err != nil {
+		return err
+	}
+
+	c.parseMetrics(m)
+	c.statusLevel.Collect(ch)
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPluginsCollectorParseMetrics(t *testing.T) {
+	c, err := newPluginsCollector(&collectorSettings{namespace: "kibana"})
+	if err != nil {
+		t.Fatalf("newPluginsCollector failed with valid input")
+	}
+
+	e := c.(*pluginsCollector)
+
+	m := &KibanaMetrics{}
+	m.Status.Plugins = map[string]ServiceStatus{
+		"alerting":  {Level: "degraded"},
+		"reporting": {Level: "available"},
+	}
+	e.parseMetrics(m)
+
+	// plugins no longer reported are dropped
+	m.Status.Plugins = map[string]ServiceStatus{
+		"alerting": {Level: "available"},
+	}
+	e.parseMetrics(m)
+
+	expected := `
+# HELP kibana_plugin_status_level Kibana plugin status level by plugin, 1 for the current level and 0 for the others
+# TYPE kibana_plugin_status_level gauge
+kibana_plugin_status_level{level="available",plugin="alerting"} 1
+kibana_plugin_status_level{level="critical",plugin="alerting"} 0
+kibana_plugin_status_level{level="degraded",plugin="alerting"} 0
+kibana_plugin_status_level{level="unavailable",plugin="alerting"} 0
+kibana_plugin_status_level{level="unknown",plugin="alerting"} 0
+`
+	if err := testutil.CollectAndCompare(e.statusLevel, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected plugin status level output: %s", err)
+	}
+}
@@ -0,0 +1,363 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultProbeInterval = 30 * time.Second
+	defaultProbeTimeout  = 10 * time.Second
+
+	probePhaseDNS     = "dns"
+	probePhaseConnect = "connect"
+	probePhaseTLS     = "tls"
+	probePhaseTTFB    = "ttfb"
+	probePhaseTotal   = "total"
+)
+
+// ProbesConfig describes the synthetic probes to run against Kibana to
+// measure the latency as seen by a client.
+type ProbesConfig struct {
+	// Interval is the time between two probe runs, defaults to 30s
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is the maximum time a single probe can take, defaults to
+	// 10s
+	Timeout time.Duration `yaml:"timeout"`
+
+	Endpoints []ProbeEndpoint `yaml:"endpoints"`
+}
+
+// ProbeEndpoint describes a single Kibana endpoint to probe.
+type ProbeEndpoint struct {
+	// Name is used as the endpoint label value, defaults to the path
+	Name string `yaml:"name"`
+
+	// Path is the Kibana path to request, ex: /login
+	Path string `yaml:"path"`
+
+	// Method is the HTTP method to use, defaults to GET
+	Method string `yaml:"method"`
+
+	// Body is the optional JSON request body for POST requests
+	Body string `yaml:"body"`
+
+	// ExpectedStatus lists the response codes considered a success,
+	// defaults to any 2xx response code
+	ExpectedStatus []int `yaml:"expected_status"`
+}
+
+// probeTrace records the timestamps of the phases of a single request.
+// The httptrace hooks can be called from different goroutines.
+type probeTrace struct {
+	lock sync.Mutex
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+}
+
+func (t *probeTrace) set(ts *time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	*ts = time.Now()
+}
+
+func (t *probeTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart: func(string, string) {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+
+			// multiple addresses can be tried, keep the first attempt
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.set(&t.connectDone)
+			}
+		},
+		TLSHandshakeStart:    func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	}
+}
+
+// Prober periodically requests the configured Kibana endpoints and
+// records the client side latency. It implements the
+// prometheus.Collector interface.
+type Prober struct {
+	collector *KibanaCollector
+	client    *http.Client
+	interval  time.Duration
+	timeout   time.Duration
+	endpoints []ProbeEndpoint
+
+	// metrics
+	duration   *prometheus.HistogramVec
+	success    *prometheus.GaugeVec
+	statusCode *prometheus.GaugeVec
+	failures   *prometheus.CounterVec
+}
+
+// NewProber will validate the probes configuration and create a Prober
+// struct. The probes use the TLS settings and the credentials of the
+// given collector, but do not reuse connections so that the connection
+// setup is measured on every probe.
+func NewProber(namespace string, constLabels prometheus.Labels, collector *KibanaCollector, config ProbesConfig) (*Prober, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		return nil, errors.New("namespace cannot be empty")
+	}
+
+	if config.Interval < 0 || config.Timeout < 0 {
+		return nil, errors.New("probe interval and timeout cannot be negative")
+	}
+
+	if config.Interval == 0 {
+		config.Interval = defaultProbeInterval
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultProbeTimeout
+	}
+
+	names := map[string]bool{}
+	endpoints := make([]ProbeEndpoint, 0, len(config.Endpoints))
+	for _, ep := range config.Endpoints {
+		if !strings.HasPrefix(ep.Path, "/") {
+			return nil, fmt.Errorf("probe path should start with /: %q", ep.Path)
+		}
+
+		if ep.Name == "" {
+			ep.Name = ep.Path
+		}
+
+		if names[ep.Name] {
+			return nil, fmt.Errorf("probe %s is declared more than once", ep.Name)
+		}
+
+		names[ep.Name] = true
+
+		ep.Method = strings.ToUpper(strings.TrimSpace(ep.Method))
+		if ep.Method == "" {
+			ep.Method = http.MethodGet
+		}
+
+		endpoints = append(endpoints, ep)
+	}
+
+	var transport *http.Transport
+	if tr, ok := collector.client.Transport.(*http.Transport); ok && tr != nil {
+		transport = tr.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	transport.DisableKeepAlives = true
+
+	prober := &Prober{
+		collector: collector,
+		client: &http.Client{
+			Transport: transport,
+			// report redirects, ex: to the login page, as they are
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		interval:  config.Interval,
+		timeout:   config.Timeout,
+		endpoints: endpoints,
+
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "probe_duration_seconds",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana synthetic probe duration in seconds by endpoint and request phase",
+				Buckets:     prometheus.DefBuckets,
+			},
+			[]string{"endpoint", "phase"}),
+		success: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "probe_success",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Whether the last Kibana synthetic probe of the endpoint succeeded",
+			},
+			[]string{"endpoint"}),
+		statusCode: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "probe_http_status_code",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Response code of the last Kibana synthetic probe of the endpoint, 0 if there was no response",
+			},
+			[]string{"endpoint"}),
+		failures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "probe_failures_total",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana synthetic probe failure count by endpoint",
+			},
+			[]string{"endpoint"}),
+	}
+
+	for _, ep := range endpoints {
+		// initialize so that the series exist before the first failure
+		prober.failures.WithLabelValues(ep.Name)
+	}
+
+	return prober, nil
+}
+
+// Run will probe the configured endpoints, once immediately and then on
+// every interval, until the context is cancelled.
+func (p *Prober) Run(ctx context.Context) {
+	log.Info().
+		Msgf("starting %d synthetic probes every %s", len(p.endpoints), p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, ep := range p.endpoints {
+			p.probe(ctx, ep)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Msg("stopping synthetic probes")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe will request the endpoint once and record the result.
+func (p *Prober) probe(ctx context.Context, ep ProbeEndpoint) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if ep.Body != "" {
+		body = strings.NewReader(ep.Body)
+	}
+
+	trace := &probeTrace{}
+	req, err := p.collector.newRequest(httptrace.WithClientTrace(ctx, trace.clientTrace()), ep.Method, ep.Path, body)
+	if err != nil {
+		p.fail(ep, 0, err)
+		return
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.fail(ep, 0, err)
+		return
+	}
+
+	// the full response is part of the latency a user would see
+	_, err = io.Copy(io.Discard, resp.Body)
+	end := time.Now()
+
+	// CWE-703
+	if cerr := resp.Body.Close(); cerr != nil {
+		log.Warn().Msgf("error while closing probe response body: %s", cerr)
+	}
+
+	if err != nil {
+		p.fail(ep, resp.StatusCode, err)
+		return
+	}
+
+	trace.lock.Lock()
+	p.observe(ep, probePhaseDNS, trace.dnsStart, trace.dnsDone)
+	p.observe(ep, probePhaseConnect, trace.connectStart, trace.connectDone)
+	p.observe(ep, probePhaseTLS, trace.tlsStart, trace.tlsDone)
+	p.observe(ep, probePhaseTTFB, start, trace.firstByte)
+	trace.lock.Unlock()
+	p.observe(ep, probePhaseTotal, start, end)
+
+	if !expectedStatus(ep, resp.StatusCode) {
+		p.fail(ep, resp.StatusCode, fmt.Errorf("unexpected response code %d", resp.StatusCode))
+		return
+	}
+
+	log.Debug().
+		Msgf("probe %s succeeded in %s", ep.Name, end.Sub(start))
+
+	p.success.WithLabelValues(ep.Name).Set(1)
+	p.statusCode.WithLabelValues(ep.Name).Set(float64(resp.StatusCode))
+}
+
+// observe records the duration of a phase, if the phase happened. DNS,
+// connect, and TLS phases do not happen for IP addresses and plain text
+// URLs.
+func (p *Prober) observe(ep ProbeEndpoint, phase string, start, end time.Time) {
+	if start.IsZero() || end.IsZero() {
+		return
+	}
+
+	p.duration.WithLabelValues(ep.Name, phase).Observe(end.Sub(start).Seconds())
+}
+
+func (p *Prober) fail(ep ProbeEndpoint, code int, err error) {
+	log.Warn().
+		Msgf("probe %s failed: %s", ep.Name, err)
+
+	p.success.WithLabelValues(ep.Name).Set(0)
+	p.statusCode.WithLabelValues(ep.Name).Set(float64(code))
+	p.failures.WithLabelValues(ep.Name).Inc()
+}
+
+func expectedStatus(ep ProbeEndpoint, code int) bool {
+	if len(ep.ExpectedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	for _, c := range ep.ExpectedStatus {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Describe is the Prober implementing prometheus.Collector
+func (p *Prober) Describe(ch chan<- *prometheus.Desc) {
+	p.duration.Describe(ch)
+	p.success.Describe(ch)
+	p.statusCode.Describe(ch)
+	p.failures.Describe(ch)
+}
+
+// Collect is the Prober implementing prometheus.Collector
+func (p *Prober) Collect(ch chan<- prometheus.Metric) {
+	p.duration.Collect(ch)
+	p.success.Collect(ch)
+	p.statusCode.Collect(ch)
+	p.failures.Collect(ch)
+}
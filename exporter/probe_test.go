@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProberProbe(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/login" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", true)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	p, err := NewProber("kibana", nil, collector, ProbesConfig{
+		Endpoints: []ProbeEndpoint{
+			{Name: "login", Path: "/login"},
+			{Path: "/missing"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewProber failed with valid input: %s", err)
+	}
+
+	for _, ep := range p.endpoints {
+		p.probe(context.Background(), ep)
+	}
+
+	if v := testutil.ToFloat64(p.success.WithLabelValues("login")); v != 1 {
+		t.Errorf("expected login probe to succeed, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(p.success.WithLabelValues("/missing")); v != 0 {
+		t.Errorf("expected probe of a missing page to fail, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(p.statusCode.WithLabelValues("/missing")); v != http.StatusNotFound {
+		t.Errorf("expected status code 404, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(p.failures.WithLabelValues("/missing")); v != 1 {
+		t.Errorf("expected 1 failure, got %f", v)
+	}
+
+	// connect, tls, ttfb, and total for each endpoint, no dns for an IP
+	if c := testutil.CollectAndCount(p.duration); c != 8 {
+		t.Errorf("expected 8 duration series, got %d", c)
+	}
+}
+
+func TestNewProberDuplicateEndpoint(t *testing.T) {
+	_, err := NewProber("kibana", nil, &KibanaCollector{client: &http.Client{}}, ProbesConfig{
+		Endpoints: []ProbeEndpoint{
+			{Path: "/login"},
+			{Path: "/login"},
+		},
+	})
+	if err == nil {
+		t.Errorf("expected error for duplicate probe endpoints")
+	}
+}
@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultPushJob      = "kibana"
+	defaultPushInterval = time.Minute
+	defaultPushTimeout  = 10 * time.Second
+)
+
+// PushConfig describes the Pushgateway to push the metrics to, for Kibana
+// instances Prometheus can't reach.
+type PushConfig struct {
+	// URL is the Pushgateway base URL, ex: https://pushgateway:9091,
+	// pushing is disabled if empty
+	URL string `yaml:"url"`
+
+	// Job is the job label of the pushed metrics, defaults to kibana
+	Job string `yaml:"job"`
+
+	// Interval is the time between two pushes, defaults to 1m
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is the maximum time a single push can take, defaults to
+	// 10s
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Grouping adds labels to the grouping key, in addition to the job,
+	// ex: instance
+	Grouping map[string]string `yaml:"grouping"`
+
+	// Username and Password are used for basic auth if both are set
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	TLS ClientTLSConfig `yaml:"tls"`
+}
+
+// ClientTLSConfig configures the TLS connection to the Pushgateway or the
+// remote write endpoint.
+type ClientTLSConfig struct {
+	// CAFile is the CA certificate to verify the server with, instead of
+	// the system roots
+	CAFile string `yaml:"ca_file"`
+
+	// CertFile and KeyFile are the client certificate and key, for
+	// servers that require client authentication
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// tlsConfig builds the TLS configuration, nil if nothing is configured.
+func (c ClientTLSConfig) tlsConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	//#nosec G402 -- user defined
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading CA file: %s", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("both cert_file and key_file should be set for the client certificate")
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading client certificate: %s", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Pusher periodically gathers the metrics from a registry and pushes them
+// to a Pushgateway, replacing the metrics of the grouping key on every
+// push.
+type Pusher struct {
+	pusher   *push.Pusher
+	url      string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// NewPusher will validate the push configuration and create a Pusher
+// struct that pushes the metrics of the given gatherer.
+func NewPusher(gatherer prometheus.Gatherer, config PushConfig) (*Pusher, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid push URL %q, should be an http or https URL", config.URL)
+	}
+
+	if config.Job == "" {
+		config.Job = defaultPushJob
+	}
+
+	if config.Interval == 0 {
+		config.Interval = defaultPushInterval
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultPushTimeout
+	}
+
+	if config.Interval < 0 || config.Timeout < 0 {
+		return nil, errors.New("push interval and timeout cannot be negative")
+	}
+
+	tlsConfig, err := config.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil && u.Scheme != "https" {
+		log.Info().
+			Msgf("push TLS settings are ignored for a plain text URL: %s", config.URL)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	pusher := push.New(config.URL, config.Job).
+		Gatherer(gatherer).
+		Client(client)
+
+	for name, value := range config.Grouping {
+		if !model.LabelName(name).IsValid() || name == "job" || strings.HasPrefix(name, "__") || value == "" {
+			return nil, fmt.Errorf("invalid push grouping label %s=%q", name, value)
+		}
+
+		pusher = pusher.Grouping(name, value)
+	}
+
+	if config.Username != "" && config.Password != "" {
+		pusher = pusher.BasicAuth(config.Username, config.Password)
+	} else if config.Username != "" || config.Password != "" {
+		log.Info().
+			Msg("push username or password is not provided, pushing without basic auth")
+	}
+
+	return &Pusher{
+		pusher:   pusher,
+		url:      config.URL,
+		interval: config.Interval,
+		timeout:  config.Timeout,
+	}, nil
+}
+
+// Push will gather and push the metrics once.
+func (p *Pusher) Push(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := p.pusher.PushContext(ctx); err != nil {
+		return fmt.Errorf("error while pushing metrics to %s: %s", p.url, err)
+	}
+
+	log.Debug().
+		Msgf("pushed metrics to %s in %s", p.url, time.Since(start))
+
+	return nil
+}
+
+// Run will push the metrics, once immediately and then on every interval,
+// until the context is cancelled.
+func (p *Pusher) Run(ctx context.Context) {
+	log.Info().
+		Msgf("pushing metrics to %s every %s", p.url, p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Push(ctx); err != nil {
+			log.Warn().
+				Msgf("%s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Msg("stopping metrics push")
+			return
+		case <-ticker.C:
+		}
+	}
+}
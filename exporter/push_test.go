@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func pushTestRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kibana_up",
+		Help: "Whether Kibana responded to the status request of the last scrape",
+	})
+	gauge.Set(1)
+	reg.MustRegister(gauge)
+
+	return reg
+}
+
+func TestNewPusherInvalid(t *testing.T) {
+	configs := map[string]PushConfig{
+		"no URL":            {},
+		"no scheme":         {URL: "pushgateway:9091"},
+		"unsupported":       {URL: "ftp://pushgateway:9091"},
+		"job grouping":      {URL: "http://pushgateway:9091", Grouping: map[string]string{"job": "other"}},
+		"invalid grouping":  {URL: "http://pushgateway:9091", Grouping: map[string]string{"in-valid": "x"}},
+		"empty grouping":    {URL: "http://pushgateway:9091", Grouping: map[string]string{"instance": ""}},
+		"negative interval": {URL: "http://pushgateway:9091", Interval: -time.Second},
+		"missing key":       {URL: "https://pushgateway:9091", TLS: ClientTLSConfig{CertFile: "client.crt"}},
+		"missing CA file":   {URL: "https://pushgateway:9091", TLS: ClientTLSConfig{CAFile: "does-not-exist.crt"}},
+	}
+
+	for desc, config := range configs {
+		if _, err := NewPusher(pushTestRegistry(), config); err == nil {
+			t.Errorf("expected an error for %s", desc)
+		}
+	}
+}
+
+func TestPusherPush(t *testing.T) {
+	var method, path, auth, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p, err := NewPusher(pushTestRegistry(), PushConfig{
+		URL:      ts.URL,
+		Grouping: map[string]string{"instance": "kibana-01"},
+		Username: "pusher",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewPusher failed with valid input: %s", err)
+	}
+
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("unexpected push error: %s", err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("expected the metrics of the group to be replaced with PUT, got %s", method)
+	}
+
+	if path != "/metrics/job/kibana/instance/kibana-01" {
+		t.Errorf("unexpected grouping key path %s", path)
+	}
+
+	// base64 of pusher:secret
+	if auth != "Basic cHVzaGVyOnNlY3JldA==" {
+		t.Errorf("expected basic auth, got %q", auth)
+	}
+
+	if !strings.Contains(body, "kibana_up") {
+		t.Errorf("expected the gathered metrics to be pushed")
+	}
+}
+
+func TestPusherPushTLS(t *testing.T) {
+	pushed := false
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatalf("error while writing CA file: %s", err)
+	}
+
+	untrusted, err := NewPusher(pushTestRegistry(), PushConfig{URL: ts.URL})
+	if err != nil {
+		t.Fatalf("NewPusher failed with valid input: %s", err)
+	}
+
+	if err := untrusted.Push(context.Background()); err == nil || pushed {
+		t.Errorf("expected the push to fail without trusting the CA")
+	}
+
+	p, err := NewPusher(pushTestRegistry(), PushConfig{URL: ts.URL, TLS: ClientTLSConfig{CAFile: caFile}})
+	if err != nil {
+		t.Fatalf("NewPusher failed with valid input: %s", err)
+	}
+
+	if err := p.Push(context.Background()); err != nil || !pushed {
+		t.Errorf("expected the push to succeed with the CA file, got %v", err)
+	}
+}
+
+func TestPusherPushError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	p, err := NewPusher(pushTestRegistry(), PushConfig{URL: ts.URL})
+	if err != nil {
+		t.Fatalf("NewPusher failed with valid input: %s", err)
+	}
+
+	if err := p.Push(context.Background()); err == nil {
+		t.Errorf("expected an error for a rejected push")
+	}
+}
@@ -0,0 +1,177 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// statusPath is the Kibana API the readiness of the exporter is based on
+const statusPath = "/api/status"
+
+// targetHealth is the outcome of the requests to a single Kibana API path.
+type targetHealth struct {
+	lastAttempt  time.Time
+	lastSuccess  time.Time
+	responseCode int
+	lastError    string
+}
+
+// healthTracker records the outcome of the requests to Kibana by API path,
+// to report the readiness of the exporter.
+type healthTracker struct {
+	lock    sync.Mutex
+	targets map[string]*targetHealth
+
+	// refreshing guards against concurrent readiness checks piling up
+	// requests to an unresponsive Kibana
+	refreshing sync.Mutex
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		targets: map[string]*targetHealth{},
+	}
+}
+
+// record will update the health of the path with the outcome of a request,
+// ignoring the query string so that paged requests are a single target.
+func (h *healthTracker) record(path string, code int, err error, now time.Time) {
+	path, _, _ = strings.Cut(path, "?")
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	t, ok := h.targets[path]
+	if !ok {
+		t = &targetHealth{}
+		h.targets[path] = t
+	}
+
+	t.lastAttempt = now
+	t.responseCode = code
+	if err != nil {
+		t.lastError = err.Error()
+		return
+	}
+
+	t.lastSuccess = now
+	t.lastError = ""
+}
+
+// TargetReadiness is the readiness of a single Kibana API path.
+type TargetReadiness struct {
+	Target       string     `json:"target"`
+	Ready        bool       `json:"ready"`
+	LastAttempt  *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	ResponseCode int        `json:"response_code,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Readiness is the body of the readiness endpoint. The exporter is ready
+// if the Kibana status API responded within the window, the other targets
+// are reported for information.
+type Readiness struct {
+	Ready   bool              `json:"ready"`
+	Window  string            `json:"window"`
+	Targets []TargetReadiness `json:"targets"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// readiness will report the targets that succeeded within the window
+// before now as ready.
+func (h *healthTracker) readiness(window time.Duration, now time.Time) *Readiness {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	r := &Readiness{
+		Window:  window.String(),
+		Targets: []TargetReadiness{},
+	}
+
+	for path, t := range h.targets {
+		ready := !t.lastSuccess.IsZero() && now.Sub(t.lastSuccess) <= window
+		if path == statusPath {
+			r.Ready = ready
+		}
+
+		r.Targets = append(r.Targets, TargetReadiness{
+			Target:       path,
+			Ready:        ready,
+			LastAttempt:  optionalTime(t.lastAttempt),
+			LastSuccess:  optionalTime(t.lastSuccess),
+			ResponseCode: t.responseCode,
+			LastError:    t.lastError,
+		})
+	}
+
+	sort.Slice(r.Targets, func(i, j int) bool {
+		return r.Targets[i].Target < r.Targets[j].Target
+	})
+
+	return r
+}
+
+// Readiness returns whether the Kibana status API responded within the
+// window. If it did not, the status is requested once more with the
+// context, so that the exporter can become ready without being scraped.
+func (c *KibanaCollector) Readiness(ctx context.Context, window time.Duration) *Readiness {
+	r := c.health.readiness(window, time.Now())
+	if r.Ready {
+		return r
+	}
+
+	// only a single refresh at a time, the others report the current
+	// readiness
+	if !c.health.refreshing.TryLock() {
+		return r
+	}
+	defer c.health.refreshing.Unlock()
+
+	log.Debug().
+		Msg("kibana status is stale, refreshing for the readiness check")
+
+	// the outcome is recorded by scrape
+	_, _ = c.scrape(ctx)
+
+	return c.health.readiness(window, time.Now())
+}
+
+// ReadyHandler returns an HTTP handler that responds with the readiness
+// of the exporter as JSON, with a 200 if ready and a 503 otherwise.
+func ReadyHandler(collector *KibanaCollector, window time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		readiness := collector.Readiness(r.Context(), window)
+
+		w.Header().Set("Content-Type", "application/json")
+		if readiness.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		if err := json.NewEncoder(w).Encode(readiness); err != nil {
+			log.Warn().
+				Msgf("error while writing response to /ready call: %s", err)
+		}
+	})
+}
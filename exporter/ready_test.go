@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthTrackerReadiness(t *testing.T) {
+	now := time.Now()
+	h := newHealthTracker()
+
+	r := h.readiness(time.Minute, now)
+	if r.Ready || len(r.Targets) != 0 {
+		t.Errorf("expected not ready without any requests, got %+v", r)
+	}
+
+	h.record(statusPath, http.StatusOK, nil, now.Add(-30*time.Second))
+	h.record("/internal/reporting/jobs/list?page=0", http.StatusOK, nil, now.Add(-2*time.Minute))
+	h.record("/internal/reporting/jobs/list?page=1", http.StatusForbidden, errors.New("forbidden"), now.Add(-2*time.Minute))
+
+	r = h.readiness(time.Minute, now)
+	if !r.Ready {
+		t.Errorf("expected ready with a recent status response")
+	}
+
+	if len(r.Targets) != 2 {
+		t.Fatalf("expected the query string to be ignored, got targets %+v", r.Targets)
+	}
+
+	reporting := r.Targets[1]
+	if reporting.Target != "/internal/reporting/jobs/list" || reporting.Ready || reporting.LastError != "forbidden" || reporting.ResponseCode != http.StatusForbidden {
+		t.Errorf("unexpected reporting target readiness %+v", reporting)
+	}
+
+	if reporting.LastSuccess == nil || !reporting.LastSuccess.Equal(now.Add(-2*time.Minute)) {
+		t.Errorf("expected the last success to be kept after a failure, got %v", reporting.LastSuccess)
+	}
+
+	// the status response is now outside the window
+	r = h.readiness(time.Minute, now.Add(time.Minute))
+	if r.Ready {
+		t.Errorf("expected not ready with a stale status response")
+	}
+
+	h.record(statusPath, 0, errors.New("connection refused"), now)
+	r = h.readiness(time.Minute, now)
+	if !r.Ready {
+		t.Errorf("expected ready while the last success is within the window")
+	}
+
+	if r.Targets[0].LastError != "connection refused" {
+		t.Errorf("expected the last error to be reported, got %+v", r.Targets[0])
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	var up atomic.Bool
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"name":"kibana","status":{"overall":{"level":"available"}}}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	handler := ReadyHandler(collector, time.Minute)
+	check := func(expectedCode int) *Readiness {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if rec.Code != expectedCode {
+			t.Errorf("expected response code %d, got %d", expectedCode, rec.Code)
+		}
+
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected a JSON response, got %s", ct)
+		}
+
+		r := &Readiness{}
+		if err := json.Unmarshal(rec.Body.Bytes(), r); err != nil {
+			t.Fatalf("invalid readiness response: %s", err)
+		}
+
+		return r
+	}
+
+	// the stale status is refreshed by the check
+	r := check(http.StatusServiceUnavailable)
+	if len(r.Targets) != 1 || r.Targets[0].LastError == "" || r.Targets[0].ResponseCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the failed status request to be reported, got %+v", r.Targets)
+	}
+
+	up.Store(true)
+	r = check(http.StatusOK)
+	if !r.Ready || !r.Targets[0].Ready || r.Targets[0].LastError != "" {
+		t.Errorf("expected ready after a successful status request, got %+v", r)
+	}
+
+	// no refresh while the status is within the window
+	before := requests.Load()
+	check(http.StatusOK)
+	if requests.Load() != before {
+		t.Errorf("expected no status request while ready")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ready", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for a POST request, got %d", rec.Code)
+	}
+}
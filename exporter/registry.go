@@ -0,0 +1,233 @@
+package exporter
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// subCollector is a named group of metrics, that can be enabled or
+// disabled with the -collector.<name> and -no-collector.<name> flags.
+type subCollector interface {
+	// Describe sends the descriptors of all the metrics the collector
+	// could export
+	Describe(ch chan<- *prometheus.Desc)
+
+	// update sends the current metrics of the collector. Metrics that
+	// could be read can be sent even if an error is returned.
+	update(s *scrape, ch chan<- prometheus.Metric) error
+}
+
+// statusObserver is implemented by the collectors that remember previous
+// observations of the Kibana status, so that they can also be fed by the
+// background status polling.
+type statusObserver interface {
+	observe(m *KibanaMetrics, now time.Time)
+}
+
+// collectorSettings is what the collector factories get to build a
+// collector with.
+type collectorSettings struct {
+	namespace     string
+	constLabels   prometheus.Labels
+	collector     *KibanaCollector
+	legacyStatus  bool
+	naming        string
+	customMetrics []CustomMetricsEndpoint
+
+	// reportingInterval is the minimum time between two walks of the
+	// reporting jobs list
+	reportingInterval time.Duration
+}
+
+// collectorFactory builds a collector. A nil collector without an error
+// means the collector has nothing to collect with the given settings.
+type collectorFactory func(s *collectorSettings) (subCollector, error)
+
+type collectorRegistration struct {
+	help             string
+	enabledByDefault bool
+	factory          collectorFactory
+}
+
+var (
+	// collectorRegistrations are all the known collectors by name,
+	// populated with registerCollector from the init functions of the
+	// files implementing them
+	collectorRegistrations = map[string]collectorRegistration{}
+)
+
+func registerCollector(name, help string, enabledByDefault bool, factory collectorFactory) {
+	collectorRegistrations[name] = collectorRegistration{
+		help:             help,
+		enabledByDefault: enabledByDefault,
+		factory:          factory,
+	}
+}
+
+// collectorNames returns the names of all the known collectors, sorted.
+func collectorNames() []string {
+	names := make([]string, 0, len(collectorRegistrations))
+	for name := range collectorRegistrations {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// defaultCollectors returns the names of the collectors that are enabled
+// by default, sorted.
+func defaultCollectors() []string {
+	var names []string
+	for _, name := range collectorNames() {
+		if collectorRegistrations[name].enabledByDefault {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// collectorFlag is one of the -collector.<name> or -no-collector.<name>
+// flags. Both flags of a collector share the enabled state, so the last
+// one on the command line wins.
+type collectorFlag struct {
+	enabled *bool
+	negated bool
+}
+
+func (f *collectorFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *collectorFlag) String() string {
+	if f.enabled == nil || f.negated {
+		return "false"
+	}
+
+	return strconv.FormatBool(*f.enabled)
+}
+
+func (f *collectorFlag) Set(value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	*f.enabled = v != f.negated
+	return nil
+}
+
+// CollectorFlags holds the enabled state of the collectors, as set by the
+// -collector.<name> and -no-collector.<name> flags.
+type CollectorFlags struct {
+	enabled map[string]*bool
+}
+
+// NewCollectorFlags will define the -collector.<name> and
+// -no-collector.<name> flags of all the known collectors on the given
+// flag set.
+func NewCollectorFlags(fs *flag.FlagSet) *CollectorFlags {
+	f := &CollectorFlags{enabled: map[string]*bool{}}
+	for _, name := range collectorNames() {
+		reg := collectorRegistrations[name]
+		enabled := reg.enabledByDefault
+		f.enabled[name] = &enabled
+
+		fs.Var(&collectorFlag{enabled: &enabled}, "collector."+name, fmt.Sprintf("Enable the %s collector, %s", name, reg.help))
+		fs.Var(&collectorFlag{enabled: &enabled, negated: true}, "no-collector."+name, fmt.Sprintf("Disable the %s collector", name))
+	}
+
+	return f
+}
+
+// Enabled returns the names of the enabled collectors, sorted.
+func (f *CollectorFlags) Enabled() []string {
+	var names []string
+	for _, name := range collectorNames() {
+		if *f.enabled[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// namedCollector is an enabled collector and its name.
+type namedCollector struct {
+	name string
+	subCollector
+}
+
+// newCollectors will build the named collectors with the given settings,
+// skipping the ones that have nothing to collect.
+func newCollectors(names []string, settings *collectorSettings) ([]namedCollector, error) {
+	var collectors []namedCollector
+	for _, name := range names {
+		reg, ok := collectorRegistrations[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+
+		c, err := reg.factory(settings)
+		if err != nil {
+			return nil, fmt.Errorf("error while initializing %s collector: %s", name, err)
+		}
+
+		if c == nil {
+			log.Debug().
+				Msgf("%s collector has nothing to collect, skipping", name)
+			continue
+		}
+
+		collectors = append(collectors, namedCollector{name: name, subCollector: c})
+	}
+
+	return collectors, nil
+}
+
+// scrape is shared between the collectors running for a single Collect
+// call, so that the Kibana status is requested at most once per scrape
+// however many collectors use it.
+type scrape struct {
+	// ctx cancels the Kibana requests of the scrape
+	ctx       context.Context
+	collector *KibanaCollector
+	now       time.Time
+
+	once    sync.Once
+	metrics *KibanaMetrics
+	err     error
+}
+
+func newScrape(ctx context.Context, collector *KibanaCollector, now time.Time) *scrape {
+	return &scrape{ctx: ctx, collector: collector, now: now}
+}
+
+// status returns the Kibana status, requesting it on the first call.
+func (s *scrape) status() (*KibanaMetrics, error) {
+	s.once.Do(func() {
+		log.Trace().
+			Msg("issueing a scrape() call to the collector")
+
+		s.metrics, s.err = s.collector.scrape(s.ctx)
+		if s.err != nil {
+			return
+		}
+
+		// output for debugging
+		log.Debug().
+			Interface("metrics", s.metrics).
+			Msg("returned metrics content")
+	})
+
+	return s.metrics, s.err
+}
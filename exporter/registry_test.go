@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testCollector implements prometheus.Collector to test a subCollector
+// on its own, with the given scrape or a new one on each collection.
+type testCollector struct {
+	subCollector
+	s *scrape
+}
+
+func (c testCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.s
+	if s == nil {
+		s = newScrape(context.Background(), nil, time.Now())
+	}
+
+	_ = c.update(s, ch)
+}
+
+var collectorFlagsTests = []struct {
+	desc    string
+	args    []string
+	enabled []string
+}{
+	{
+		desc:    "defaults",
+		args:    nil,
+		enabled: []string{"custom", "http", "os", "process", "schema", "status"},
+	},
+	{
+		desc:    "enable and disable",
+		args:    []string{"-collector.plugins", "--no-collector.os", "-collector.http=false"},
+		enabled: []string{"custom", "plugins", "process", "schema", "status"},
+	},
+	{
+		desc:    "last flag wins",
+		args:    []string{"-collector.reporting", "-no-collector.reporting", "-no-collector.status", "-collector.status"},
+		enabled: []string{"custom", "http", "os", "process", "schema", "status"},
+	},
+}
+
+func TestCollectorFlags(t *testing.T) {
+	for _, ct := range collectorFlagsTests {
+		t.Run(ct.desc, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f := NewCollectorFlags(fs)
+			if err := fs.Parse(ct.args); err != nil {
+				t.Fatalf("unexpected error while parsing flags: %s", err)
+			}
+
+			if enabled := f.Enabled(); !reflect.DeepEqual(enabled, ct.enabled) {
+				t.Errorf("expected enabled collectors %v, got %v", ct.enabled, enabled)
+			}
+		})
+	}
+}
+
+func TestNewCollectorsSkipsUnconfigured(t *testing.T) {
+	collectors, err := newCollectors([]string{"custom", "status"}, &collectorSettings{namespace: "kibana"})
+	if err != nil {
+		t.Fatalf("newCollectors failed with valid input: %s", err)
+	}
+
+	if len(collectors) != 1 || collectors[0].name != "status" {
+		t.Errorf("expected only the status collector without custom metrics, got %v", collectors)
+	}
+}
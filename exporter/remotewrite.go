@@ -0,0 +1,606 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRemoteWriteJob        = "kibana"
+	defaultRemoteWriteInterval   = time.Minute
+	defaultRemoteWriteTimeout    = 30 * time.Second
+	defaultRemoteWriteQueueSize  = 10
+	defaultRemoteWriteMaxRetries = 3
+	defaultRemoteWriteMinBackoff = time.Second
+	defaultRemoteWriteMaxBackoff = 30 * time.Second
+
+	remoteWriteDroppedQueueFull = "queue_full"
+	remoteWriteDroppedRejected  = "rejected"
+)
+
+// remoteWriteReservedHeaders are set by the remote writer and cannot be
+// overridden in the configuration.
+var remoteWriteReservedHeaders = []string{
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"User-Agent",
+	"X-Prometheus-Remote-Write-Version",
+}
+
+// RemoteWriteConfig describes the Prometheus remote write endpoint to send
+// the metrics to, ex: Mimir, Thanos Receive or a Prometheus with the
+// remote write receiver enabled.
+type RemoteWriteConfig struct {
+	// URL is the remote write endpoint, ex: https://mimir/api/v1/push,
+	// remote write is disabled if empty
+	URL string `yaml:"url"`
+
+	// Interval is the time between two writes, defaults to 1m
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is the maximum time a single request can take, defaults to
+	// 30s
+	Timeout time.Duration `yaml:"timeout"`
+
+	// ExternalLabels are added to every series, unless the series already
+	// has the label. The job label defaults to kibana.
+	ExternalLabels map[string]string `yaml:"external_labels"`
+
+	// Headers are added to every request, ex: X-Scope-OrgID for
+	// multi-tenant receivers
+	Headers map[string]string `yaml:"headers"`
+
+	// QueueSize is the number of writes kept while the endpoint is not
+	// reachable, the oldest are dropped when full, defaults to 10
+	QueueSize int `yaml:"queue_size"`
+
+	// MaxRetries is the number of retries of a failed request before
+	// keeping it in the queue for the next interval, 0 does not retry,
+	// defaults to 3
+	MaxRetries *int `yaml:"max_retries"`
+
+	// MinBackoff and MaxBackoff bound the delay between two retries,
+	// defaults to 1s and 30s
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+
+	// Username and Password are used for basic auth if both are set
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	TLS ClientTLSConfig `yaml:"tls"`
+}
+
+// writeBatch is an encoded WriteRequest waiting to be sent.
+type writeBatch struct {
+	body    []byte
+	samples int
+}
+
+// writeError is a failed remote write request. Only recoverable errors
+// are retried, the others would fail again with the same data.
+type writeError struct {
+	err         error
+	recoverable bool
+	retryAfter  time.Duration
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+// RemoteWriter periodically gathers the metrics from a registry and sends
+// them to a Prometheus remote write endpoint, as snappy compressed
+// protobuf WriteRequests. Writes that fail while the endpoint is not
+// reachable are queued and sent in order once it is. It implements the
+// prometheus.Collector interface for its own metrics.
+type RemoteWriter struct {
+	gatherer   prometheus.Gatherer
+	client     *http.Client
+	config     RemoteWriteConfig
+	labels     []prompb.Label
+	maxRetries int
+
+	// lock serializes the writes, and guards the queue and the random
+	// source
+	lock  sync.Mutex
+	queue []writeBatch
+	rnd   *rand.Rand
+
+	// metrics
+	sentSamples    prometheus.Counter
+	droppedSamples *prometheus.CounterVec
+	failures       prometheus.Counter
+	queueLength    prometheus.Gauge
+	lastSuccess    prometheus.Gauge
+}
+
+// NewRemoteWriter will validate the remote write configuration and create
+// a RemoteWriter struct that sends the metrics of the given gatherer.
+func NewRemoteWriter(namespace string, constLabels prometheus.Labels, gatherer prometheus.Gatherer, config RemoteWriteConfig) (*RemoteWriter, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		return nil, errors.New("namespace cannot be empty")
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote write URL %q, should be an http or https URL", config.URL)
+	}
+
+	if config.Interval < 0 || config.Timeout < 0 || config.MinBackoff < 0 || config.MaxBackoff < 0 {
+		return nil, errors.New("remote write interval, timeout and backoff cannot be negative")
+	}
+
+	maxRetries := defaultRemoteWriteMaxRetries
+	if config.MaxRetries != nil {
+		maxRetries = *config.MaxRetries
+	}
+
+	if config.QueueSize < 0 || maxRetries < 0 {
+		return nil, errors.New("remote write queue size and retries cannot be negative")
+	}
+
+	if config.Interval == 0 {
+		config.Interval = defaultRemoteWriteInterval
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = defaultRemoteWriteTimeout
+	}
+
+	if config.QueueSize == 0 {
+		config.QueueSize = defaultRemoteWriteQueueSize
+	}
+
+	if config.MinBackoff == 0 {
+		config.MinBackoff = defaultRemoteWriteMinBackoff
+	}
+
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = defaultRemoteWriteMaxBackoff
+	}
+
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+
+	external := map[string]string{"job": defaultRemoteWriteJob}
+	for name, value := range config.ExternalLabels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") || value == "" {
+			return nil, fmt.Errorf("invalid remote write external label %s=%q", name, value)
+		}
+
+		external[name] = value
+	}
+
+	labels := make([]prompb.Label, 0, len(external))
+	for name, value := range external {
+		labels = append(labels, prompb.Label{Name: name, Value: value})
+	}
+
+	for name := range config.Headers {
+		for _, reserved := range remoteWriteReservedHeaders {
+			if strings.EqualFold(name, reserved) {
+				return nil, fmt.Errorf("remote write header %s is set by the exporter and cannot be configured", name)
+			}
+		}
+	}
+
+	tlsConfig, err := config.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil && u.Scheme != "https" {
+		log.Info().
+			Msgf("remote write TLS settings are ignored for a plain text URL: %s", config.URL)
+	}
+
+	if (config.Username == "") != (config.Password == "") {
+		log.Info().
+			Msg("remote write username or password is not provided, writing without basic auth")
+		config.Username = ""
+		config.Password = ""
+	}
+
+	w := &RemoteWriter{
+		gatherer: gatherer,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: config.Timeout,
+		},
+		config:     config,
+		labels:     labels,
+		maxRetries: maxRetries,
+		//#nosec G404 -- only used for jitter
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		sentSamples: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name:        prometheus.BuildFQName(namespace, "exporter", "remote_write_sent_samples_total"),
+				Help:        "Number of samples accepted by the remote write endpoint",
+				ConstLabels: constLabels,
+			}),
+		droppedSamples: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        prometheus.BuildFQName(namespace, "exporter", "remote_write_dropped_samples_total"),
+				Help:        "Number of samples dropped without being written, because the queue was full or the remote write endpoint rejected them",
+				ConstLabels: constLabels,
+			},
+			[]string{"reason"}),
+		failures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name:        prometheus.BuildFQName(namespace, "exporter", "remote_write_failed_requests_total"),
+				Help:        "Number of failed remote write requests, including the retries",
+				ConstLabels: constLabels,
+			}),
+		queueLength: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        prometheus.BuildFQName(namespace, "exporter", "remote_write_queue_length"),
+				Help:        "Number of writes waiting to be sent to the remote write endpoint",
+				ConstLabels: constLabels,
+			}),
+		lastSuccess: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        prometheus.BuildFQName(namespace, "exporter", "remote_write_last_success_timestamp_seconds"),
+				Help:        "Unix time of the last successful remote write request, 0 if none",
+				ConstLabels: constLabels,
+			}),
+	}
+
+	// initialize so that the series exist before the first drop
+	w.droppedSamples.WithLabelValues(remoteWriteDroppedQueueFull)
+	w.droppedSamples.WithLabelValues(remoteWriteDroppedRejected)
+
+	return w, nil
+}
+
+// Write will gather the metrics, add them to the queue and send the
+// queued writes in order. An error is returned if the endpoint could not
+// be reached, the writes are kept in the queue for the next call.
+func (w *RemoteWriter) Write(ctx context.Context) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := time.Now()
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// partial results are still written, as promhttp does
+		log.Warn().
+			Msgf("error while gathering metrics: %s", err)
+	}
+
+	series := w.series(families, now)
+	if len(series) > 0 {
+		body, err := writeRequest(series, families).Marshal()
+		if err != nil {
+			return fmt.Errorf("error while encoding the remote write request: %s", err)
+		}
+
+		w.enqueue(writeBatch{
+			body:    snappy.Encode(nil, body),
+			samples: len(series),
+		})
+	}
+
+	for len(w.queue) > 0 {
+		batch := w.queue[0]
+
+		err := w.sendWithRetry(ctx, batch)
+		if err != nil && err.recoverable {
+			return fmt.Errorf("error while writing metrics to %s, %d writes queued: %s", w.config.URL, len(w.queue), err)
+		}
+
+		if err != nil {
+			log.Warn().
+				Msgf("dropping %d samples rejected by %s: %s", batch.samples, w.config.URL, err)
+			w.droppedSamples.WithLabelValues(remoteWriteDroppedRejected).Add(float64(batch.samples))
+		} else {
+			w.sentSamples.Add(float64(batch.samples))
+			w.lastSuccess.Set(float64(time.Now().UnixNano()) / 1e9)
+		}
+
+		w.queue = w.queue[1:]
+		w.queueLength.Set(float64(len(w.queue)))
+	}
+
+	log.Debug().
+		Msgf("wrote metrics to %s in %s", w.config.URL, time.Since(now))
+
+	return nil
+}
+
+// enqueue will add the batch to the queue, dropping the oldest batch if
+// the queue is full. Must be called with the lock held.
+func (w *RemoteWriter) enqueue(batch writeBatch) {
+	if len(w.queue) >= w.config.QueueSize {
+		dropped := w.queue[0]
+		w.queue = w.queue[1:]
+
+		log.Warn().
+			Msgf("remote write queue is full, dropping the oldest %d samples", dropped.samples)
+		w.droppedSamples.WithLabelValues(remoteWriteDroppedQueueFull).Add(float64(dropped.samples))
+	}
+
+	w.queue = append(w.queue, batch)
+	w.queueLength.Set(float64(len(w.queue)))
+}
+
+// sendWithRetry will send the batch, retrying recoverable errors with
+// exponential backoff, or after the delay asked by the endpoint with
+// Retry-After. Must be called with the lock held.
+func (w *RemoteWriter) sendWithRetry(ctx context.Context, batch writeBatch) *writeError {
+	for retries := 0; ; retries++ {
+		err := w.send(ctx, batch)
+		if err == nil {
+			return nil
+		}
+
+		w.failures.Inc()
+		if !err.recoverable || retries >= w.maxRetries {
+			return err
+		}
+
+		delay := backoff(retries+1, w.config.MinBackoff, w.config.MaxBackoff, w.rnd)
+		if err.retryAfter > 0 {
+			delay = err.retryAfter
+			if delay > w.config.MaxBackoff {
+				delay = w.config.MaxBackoff
+			}
+		}
+
+		log.Debug().
+			Msgf("remote write failed, retrying in %s: %s", delay.Round(time.Millisecond), err)
+
+		select {
+		case <-ctx.Done():
+			return &writeError{err: ctx.Err(), recoverable: true}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// send will make a single remote write request. Server errors, 429 and
+// network errors are recoverable, other client errors are not.
+func (w *RemoteWriter) send(ctx context.Context, batch writeBatch) *writeError {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(batch.body))
+	if err != nil {
+		return &writeError{err: err}
+	}
+
+	for name, value := range w.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "kibana-prometheus-exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if w.config.Username != "" {
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &writeError{err: err, recoverable: true}
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		// drain so that the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	// the receivers explain the rejection in the body
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write endpoint responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+
+	return &writeError{
+		err:         err,
+		recoverable: resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests,
+		retryAfter:  parseRetryAfter(resp.Header, time.Now()),
+	}
+}
+
+// Run will write the metrics, once immediately and then on every interval,
+// until the context is cancelled.
+func (w *RemoteWriter) Run(ctx context.Context) {
+	log.Info().
+		Msgf("writing metrics to %s every %s", w.config.URL, w.config.Interval)
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Write(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().
+				Msgf("%s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Msg("stopping remote write")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// series converts the gathered metric families to remote write series.
+// Histograms and summaries are split into their bucket, quantile, sum and
+// count series, as in the text format. Metrics without a timestamp get
+// the gather time, so that queued writes keep their original time.
+func (w *RemoteWriter) series(families []*dto.MetricFamily, now time.Time) []prompb.TimeSeries {
+	ts := now.UnixMilli()
+
+	var series []prompb.TimeSeries
+	add := func(name string, m *dto.Metric, value float64, extra ...prompb.Label) {
+		labels := make([]prompb.Label, 0, len(m.GetLabel())+len(extra)+len(w.labels)+1)
+		labels = append(labels, prompb.Label{Name: model.MetricNameLabel, Value: name})
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, prompb.Label{Name: lp.GetName(), Value: lp.GetValue()})
+		}
+
+		labels = append(labels, extra...)
+
+		// the labels of the series take precedence over the external
+		// labels
+		own := len(labels)
+		for _, l := range w.labels {
+			found := false
+			for _, existing := range labels[:own] {
+				if existing.Name == l.Name {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				labels = append(labels, l)
+			}
+		}
+
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].Name < labels[j].Name
+		})
+
+		t := ts
+		if m.TimestampMs != nil {
+			t = m.GetTimestampMs()
+		}
+
+		series = append(series, prompb.TimeSeries{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: value, Timestamp: t}},
+		})
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, m, q.GetValue(), prompb.Label{Name: model.QuantileLabel, Value: formatFloat(q.GetQuantile())})
+				}
+
+				add(name+"_sum", m, s.GetSampleSum())
+				add(name+"_count", m, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.GetBucket() {
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+					add(name+"_bucket", m, float64(b.GetCumulativeCount()), prompb.Label{Name: model.BucketLabel, Value: formatFloat(b.GetUpperBound())})
+				}
+
+				if !inf {
+					add(name+"_bucket", m, float64(h.GetSampleCount()), prompb.Label{Name: model.BucketLabel, Value: "+Inf"})
+				}
+
+				add(name+"_sum", m, h.GetSampleSum())
+				add(name+"_count", m, float64(h.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+// formatFloat formats the le and quantile label values as in the text
+// format.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// remoteMetadataType maps the metric types to the metadata types of the
+// remote write protocol.
+var remoteMetadataType = map[dto.MetricType]prompb.MetricMetadata_MetricType{
+	dto.MetricType_COUNTER:         prompb.MetricMetadata_COUNTER,
+	dto.MetricType_GAUGE:           prompb.MetricMetadata_GAUGE,
+	dto.MetricType_HISTOGRAM:       prompb.MetricMetadata_HISTOGRAM,
+	dto.MetricType_GAUGE_HISTOGRAM: prompb.MetricMetadata_GAUGEHISTOGRAM,
+	dto.MetricType_SUMMARY:         prompb.MetricMetadata_SUMMARY,
+}
+
+// writeRequest builds the WriteRequest with the series and the metadata
+// of the metric families.
+func writeRequest(series []prompb.TimeSeries, families []*dto.MetricFamily) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{
+		Timeseries: series,
+		Metadata:   make([]prompb.MetricMetadata, 0, len(families)),
+	}
+
+	for _, mf := range families {
+		req.Metadata = append(req.Metadata, prompb.MetricMetadata{
+			// UNKNOWN for the untyped metrics
+			Type:             remoteMetadataType[mf.GetType()],
+			MetricFamilyName: mf.GetName(),
+			Help:             mf.GetHelp(),
+		})
+	}
+
+	return req
+}
+
+// Describe is the RemoteWriter implementing prometheus.Collector
+func (w *RemoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	w.sentSamples.Describe(ch)
+	w.droppedSamples.Describe(ch)
+	w.failures.Describe(ch)
+	w.queueLength.Describe(ch)
+	w.lastSuccess.Describe(ch)
+}
+
+// Collect is the RemoteWriter implementing prometheus.Collector
+func (w *RemoteWriter) Collect(ch chan<- prometheus.Metric) {
+	w.sentSamples.Collect(ch)
+	w.droppedSamples.Collect(ch)
+	w.failures.Collect(ch)
+	w.queueLength.Collect(ch)
+	w.lastSuccess.Collect(ch)
+}
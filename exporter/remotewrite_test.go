@@ -0,0 +1,306 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// decodedSeries is a remote write series decoded by the test receiver,
+// with the labels in the text format.
+type decodedSeries struct {
+	labels    string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decodes the series and the metadata names of a
+// snappy compressed WriteRequest.
+func decodeWriteRequest(t *testing.T, body []byte) ([]decodedSeries, []string) {
+	t.Helper()
+
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("invalid snappy body: %s", err)
+	}
+
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(raw); err != nil {
+		t.Fatalf("invalid WriteRequest: %s", err)
+	}
+
+	var series []decodedSeries
+	for _, ts := range req.Timeseries {
+		if len(ts.Samples) != 1 {
+			t.Fatalf("expected a single sample per series, got %d", len(ts.Samples))
+		}
+
+		labels := make([]string, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			labels = append(labels, l.Name+"="+l.Value)
+		}
+
+		series = append(series, decodedSeries{
+			labels:    strings.Join(labels, ","),
+			value:     ts.Samples[0].Value,
+			timestamp: ts.Samples[0].Timestamp,
+		})
+	}
+
+	var metadata []string
+	for _, m := range req.Metadata {
+		metadata = append(metadata, m.MetricFamilyName)
+	}
+
+	return series, metadata
+}
+
+func remoteWriteTestRegistry() *prometheus.Registry {
+	reg := pushTestRegistry()
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kibana_probe_duration_seconds",
+		Help:    "Kibana synthetic probe duration in seconds",
+		Buckets: []float64{0.5},
+	})
+	histogram.Observe(0.1)
+	reg.MustRegister(histogram)
+
+	return reg
+}
+
+func TestNewRemoteWriterInvalid(t *testing.T) {
+	negative := -1
+	configs := map[string]RemoteWriteConfig{
+		"no URL":           {},
+		"unsupported":      {URL: "ftp://mimir/api/v1/push"},
+		"negative backoff": {URL: "http://mimir/api/v1/push", MinBackoff: -time.Second},
+		"negative queue":   {URL: "http://mimir/api/v1/push", QueueSize: -1},
+		"negative retries": {URL: "http://mimir/api/v1/push", MaxRetries: &negative},
+		"invalid label":    {URL: "http://mimir/api/v1/push", ExternalLabels: map[string]string{"in-valid": "x"}},
+		"reserved label":   {URL: "http://mimir/api/v1/push", ExternalLabels: map[string]string{"__name__": "x"}},
+		"reserved header":  {URL: "http://mimir/api/v1/push", Headers: map[string]string{"content-type": "text/plain"}},
+		"missing CA file":  {URL: "https://mimir/api/v1/push", TLS: ClientTLSConfig{CAFile: "does-not-exist.crt"}},
+	}
+
+	for desc, config := range configs {
+		if _, err := NewRemoteWriter("kibana", nil, prometheus.NewRegistry(), config); err == nil {
+			t.Errorf("expected an error for %s", desc)
+		}
+	}
+}
+
+func TestRemoteWriterWrite(t *testing.T) {
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ = io.ReadAll(r.Body)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w, err := NewRemoteWriter("kibana", nil, remoteWriteTestRegistry(), RemoteWriteConfig{
+		URL:            ts.URL,
+		ExternalLabels: map[string]string{"instance": "kibana-01"},
+		Headers:        map[string]string{"X-Scope-OrgID": "tenant"},
+		Username:       "writer",
+		Password:       "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewRemoteWriter failed with valid input: %s", err)
+	}
+
+	before := time.Now().UnixMilli()
+	if err := w.Write(context.Background()); err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	}
+
+	expectedHeaders := map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-OrgID":                     "tenant",
+	}
+
+	for name, value := range expectedHeaders {
+		if headers.Get(name) != value {
+			t.Errorf("expected header %s: %s, got %q", name, value, headers.Get(name))
+		}
+	}
+
+	if user, pass, ok := (&http.Request{Header: headers}).BasicAuth(); !ok || user != "writer" || pass != "secret" {
+		t.Errorf("expected basic auth credentials to be sent")
+	}
+
+	series, metadata := decodeWriteRequest(t, body)
+	expected := []string{
+		"__name__=kibana_probe_duration_seconds_bucket,instance=kibana-01,job=kibana,le=0.5",
+		"__name__=kibana_probe_duration_seconds_bucket,instance=kibana-01,job=kibana,le=+Inf",
+		"__name__=kibana_probe_duration_seconds_sum,instance=kibana-01,job=kibana",
+		"__name__=kibana_probe_duration_seconds_count,instance=kibana-01,job=kibana",
+		"__name__=kibana_up,instance=kibana-01,job=kibana",
+	}
+
+	if len(series) != len(expected) {
+		t.Fatalf("expected %d series, got %+v", len(expected), series)
+	}
+
+	for i, s := range series {
+		if s.labels != expected[i] {
+			t.Errorf("expected series %s, got %s", expected[i], s.labels)
+		}
+
+		if s.timestamp < before || s.timestamp > time.Now().UnixMilli() {
+			t.Errorf("expected the gather time as the timestamp of %s, got %d", s.labels, s.timestamp)
+		}
+	}
+
+	if series[0].value != 1 || series[2].value != 0.1 || series[4].value != 1 {
+		t.Errorf("unexpected sample values: %+v", series)
+	}
+
+	if strings.Join(metadata, ",") != "kibana_probe_duration_seconds,kibana_up" {
+		t.Errorf("expected the metadata of the metric families, got %v", metadata)
+	}
+
+	if v := testutil.ToFloat64(w.sentSamples); v != 5 {
+		t.Errorf("expected 5 sent samples, got %f", v)
+	}
+
+	if testutil.ToFloat64(w.lastSuccess) == 0 {
+		t.Errorf("expected the last success time to be set")
+	}
+}
+
+func TestRemoteWriterRetry(t *testing.T) {
+	var lock sync.Mutex
+	var codes []int
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests++
+		code := http.StatusOK
+		if len(codes) > 0 {
+			code = codes[0]
+			codes = codes[1:]
+		}
+
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+
+	respond := func(c ...int) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		codes = c
+		requests = 0
+	}
+
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return requests
+	}
+
+	retries := 1
+	w, err := NewRemoteWriter("kibana", nil, pushTestRegistry(), RemoteWriteConfig{
+		URL:        ts.URL,
+		QueueSize:  2,
+		MaxRetries: &retries,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewRemoteWriter failed with valid input: %s", err)
+	}
+
+	// a server error is retried
+	respond(http.StatusServiceUnavailable)
+	if err := w.Write(context.Background()); err != nil || count() != 2 {
+		t.Fatalf("expected a successful retry, got %d requests and %v", count(), err)
+	}
+
+	// the write is queued once the retries are exhausted
+	respond(http.StatusInternalServerError, http.StatusInternalServerError)
+	if err := w.Write(context.Background()); err == nil || len(w.queue) != 1 {
+		t.Fatalf("expected the write to be queued, got %d queued writes and %v", len(w.queue), err)
+	}
+
+	// the oldest write is dropped when the queue is full
+	respond(http.StatusBadGateway, http.StatusBadGateway)
+	_ = w.Write(context.Background())
+	respond(http.StatusBadGateway, http.StatusBadGateway)
+	_ = w.Write(context.Background())
+	if len(w.queue) != 2 || testutil.ToFloat64(w.droppedSamples.WithLabelValues(remoteWriteDroppedQueueFull)) != 1 {
+		t.Fatalf("expected the oldest write to be dropped, got %d queued writes", len(w.queue))
+	}
+
+	// a client error is not retried, the next queued write is sent after
+	// it
+	respond(http.StatusBadRequest)
+	if err := w.Write(context.Background()); err != nil || count() != 2 || len(w.queue) != 0 {
+		t.Fatalf("expected the queue to be flushed, got %d requests, %d queued writes and %v", count(), len(w.queue), err)
+	}
+
+	expected := `
+# HELP kibana_exporter_remote_write_dropped_samples_total Number of samples dropped without being written, because the queue was full or the remote write endpoint rejected them
+# TYPE kibana_exporter_remote_write_dropped_samples_total counter
+kibana_exporter_remote_write_dropped_samples_total{reason="queue_full"} 2
+kibana_exporter_remote_write_dropped_samples_total{reason="rejected"} 1
+# HELP kibana_exporter_remote_write_failed_requests_total Number of failed remote write requests, including the retries
+# TYPE kibana_exporter_remote_write_failed_requests_total counter
+kibana_exporter_remote_write_failed_requests_total 8
+# HELP kibana_exporter_remote_write_queue_length Number of writes waiting to be sent to the remote write endpoint
+# TYPE kibana_exporter_remote_write_queue_length gauge
+kibana_exporter_remote_write_queue_length 0
+# HELP kibana_exporter_remote_write_sent_samples_total Number of samples accepted by the remote write endpoint
+# TYPE kibana_exporter_remote_write_sent_samples_total counter
+kibana_exporter_remote_write_sent_samples_total 2
+`
+	err = testutil.CollectAndCompare(w, strings.NewReader(expected),
+		"kibana_exporter_remote_write_dropped_samples_total",
+		"kibana_exporter_remote_write_failed_requests_total",
+		"kibana_exporter_remote_write_queue_length",
+		"kibana_exporter_remote_write_sent_samples_total")
+	if err != nil {
+		t.Errorf("unexpected remote write metrics: %s", err)
+	}
+}
+
+func TestRemoteWriterNoRetries(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	retries := 0
+	w, err := NewRemoteWriter("kibana", nil, pushTestRegistry(), RemoteWriteConfig{
+		URL:        ts.URL,
+		MaxRetries: &retries,
+	})
+	if err != nil {
+		t.Fatalf("NewRemoteWriter failed with valid input: %s", err)
+	}
+
+	if err := w.Write(context.Background()); err == nil || atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("expected a single request without retries, got %d requests and %v", atomic.LoadInt32(&requests), err)
+	}
+}
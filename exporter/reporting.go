@@ -0,0 +1,212 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// https://github.com/elastic/kibana/blob/8.7/x-pack/plugins/reporting/common/constants.ts
+	// the list API returns a fixed number of jobs per page
+	reportingJobsListPath = "/internal/reporting/jobs/list"
+
+	// upper limit for the number of pages to walk through for a single
+	// scrape, to avoid hammering Kibana when the job history is long
+	reportingMaxPages = 100
+
+	// defaultReportingInterval is the minimum time between two walks of
+	// the jobs list, each walk requests every page
+	defaultReportingInterval = time.Minute
+
+	reportingStatusPending    = "pending"
+	reportingStatusProcessing = "processing"
+)
+
+// reportingQueuedStatuses are the statuses of the jobs that are not done
+// yet, counted in the queue depth
+var reportingQueuedStatuses = []string{reportingStatusPending, reportingStatusProcessing}
+
+func init() {
+	registerCollector("reporting", "Kibana reporting job queue", false, func(s *collectorSettings) (subCollector, error) {
+		e, err := NewReportingExporter(s.namespace, s.constLabels, s.collector)
+		if err != nil {
+			return nil, err
+		}
+
+		e.interval = s.reportingInterval
+		return e, nil
+	})
+}
+
+// ReportingJob is used to unmarshal a single job from the reporting jobs
+// list response from Kibana.
+type ReportingJob struct {
+	ID        string    `json:"id"`
+	JobType   string    `json:"jobtype"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	Attempts  int       `json:"attempts"`
+}
+
+// ReportingExporter is the reporting collector, exporting the Kibana
+// reporting job queue.
+type ReportingExporter struct {
+	lock      sync.Mutex
+	collector *KibanaCollector
+
+	// interval is the minimum time between two walks of the jobs list,
+	// the jobs of the last walk are served in between
+	interval  time.Duration
+	lastFetch time.Time
+	cache     []ReportingJob
+
+	// metrics
+	jobs             *prometheus.GaugeVec
+	queueDepth       *prometheus.GaugeVec
+	oldestPendingAge prometheus.Gauge
+}
+
+// NewReportingExporter will create a ReportingExporter struct and
+// initialize the reporting metrics.
+func NewReportingExporter(namespace string, constLabels prometheus.Labels, collector *KibanaCollector) (*ReportingExporter, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		return nil, errors.New("namespace cannot be empty")
+	}
+
+	exporter := &ReportingExporter{
+		collector: collector,
+		interval:  defaultReportingInterval,
+
+		jobs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "reporting_jobs",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana reporting job count by status and job type",
+			},
+			[]string{"status", "jobtype"}),
+		queueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "reporting_queue_depth",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana reporting jobs not done yet by status, pending or processing",
+			},
+			[]string{"status"}),
+		oldestPendingAge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "reporting_oldest_pending_job_age_seconds",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Age of the oldest pending Kibana reporting job in seconds, 0 if none are pending",
+			}),
+	}
+
+	return exporter, nil
+}
+
+// scrapeReportingJobs will page through the reporting jobs list and
+// return all the jobs visible to the configured Kibana user.
+func (c *KibanaCollector) scrapeReportingJobs(ctx context.Context) ([]ReportingJob, error) {
+	var jobs []ReportingJob
+	for page := 0; page < reportingMaxPages; page++ {
+		respContent, err := c.request(ctx, http.MethodGet, fmt.Sprintf("%s?page=%d", reportingJobsListPath, page), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading Kibana reporting jobs: %s", err)
+		}
+
+		var pageJobs []ReportingJob
+		err = json.Unmarshal(respContent, &pageJobs)
+		if err != nil {
+			return nil, fmt.Errorf("error while unmarshalling Kibana reporting jobs: %s\nProblematic content:\n%s", err, respContent)
+		}
+
+		if len(pageJobs) == 0 {
+			return jobs, nil
+		}
+
+		jobs = append(jobs, pageJobs...)
+	}
+
+	log.Warn().
+		Msgf("reporting jobs list has more than %d pages, only the first pages were counted", reportingMaxPages)
+
+	return jobs, nil
+}
+
+// parseJobs will set the reporting metrics values using the list of
+// jobs returned from Kibana.
+func (e *ReportingExporter) parseJobs(jobs []ReportingJob, now time.Time) {
+	e.jobs.Reset()
+
+	queued := map[string]int{}
+	var oldest time.Time
+	for _, job := range jobs {
+		status := strings.ToLower(job.Status)
+		e.jobs.WithLabelValues(status, job.JobType).Inc()
+		queued[status]++
+
+		if status != reportingStatusPending {
+			continue
+		}
+
+		if oldest.IsZero() || job.CreatedAt.Before(oldest) {
+			oldest = job.CreatedAt
+		}
+	}
+
+	// set for all the statuses so that the series exist when the queue is
+	// empty
+	for _, status := range reportingQueuedStatuses {
+		e.queueDepth.WithLabelValues(status).Set(float64(queued[status]))
+	}
+
+	if oldest.IsZero() {
+		e.oldestPendingAge.Set(0)
+	} else {
+		e.oldestPendingAge.Set(now.Sub(oldest).Seconds())
+	}
+}
+
+// Describe is the ReportingExporter implementing subCollector
+func (e *ReportingExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.jobs.Describe(ch)
+	e.queueDepth.Describe(ch)
+	ch <- e.oldestPendingAge.Desc()
+}
+
+// update will export the reporting metrics, walking the jobs list at most
+// once per interval. The ages are computed on every scrape, also from the
+// jobs of the previous walk.
+func (e *ReportingExporter) update(s *scrape, ch chan<- prometheus.Metric) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.lastFetch.IsZero() || s.now.Sub(e.lastFetch) >= e.interval {
+		jobs, err := e.collector.scrapeReportingJobs(s.ctx)
+		if err != nil {
+			return err
+		}
+
+		e.cache = jobs
+		e.lastFetch = s.now
+	}
+
+	e.parseJobs(e.cache, s.now)
+
+	e.jobs.Collect(ch)
+	e.queueDepth.Collect(ch)
+	ch <- e.oldestPendingAge
+
+	return nil
+}
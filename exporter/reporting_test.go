@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeReportingJobsPaging(t *testing.T) {
+	pages := map[string]string{
+		"0": `[{"id":"a","jobtype":"printable_pdf_v2","status":"pending","created_at":"2023-01-01T00:00:00Z"},
+		       {"id":"b","jobtype":"PNGV2","status":"completed","created_at":"2023-01-01T00:00:00Z"}]`,
+		"1": `[{"id":"c","jobtype":"printable_pdf_v2","status":"pending","created_at":"2023-01-01T00:10:00Z"},
+		       {"id":"d","jobtype":"csv_searchsource","status":"processing","created_at":"2023-01-01T00:20:00Z"}]`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != reportingJobsListPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, ok := pages[r.URL.Query().Get("page")]
+		if !ok {
+			body = "[]"
+		}
+
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	jobs, err := collector.scrapeReportingJobs(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error while scraping reporting jobs: %s", err)
+	}
+
+	if len(jobs) != 4 {
+		t.Fatalf("expected 4 jobs across pages, got %d", len(jobs))
+	}
+
+	e, err := NewReportingExporter("kibana", nil, collector)
+	if err != nil {
+		t.Fatalf("NewReportingExporter failed with valid input")
+	}
+
+	e.parseJobs(jobs, time.Date(2023, 1, 1, 0, 30, 0, 0, time.UTC))
+
+	if v := testutil.ToFloat64(e.queueDepth.WithLabelValues("pending")); v != 2 {
+		t.Errorf("expected pending queue depth 2, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(e.queueDepth.WithLabelValues("processing")); v != 1 {
+		t.Errorf("expected processing queue depth 1, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(e.oldestPendingAge); v != 1800 {
+		t.Errorf("expected oldest pending age 1800, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(e.jobs.WithLabelValues("pending", "printable_pdf_v2")); v != 2 {
+		t.Errorf("expected 2 pending pdf jobs, got %f", v)
+	}
+}
+
+func TestReportingInterval(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("page") != "0" {
+			fmt.Fprint(w, "[]")
+			return
+		}
+
+		fmt.Fprint(w, `[{"id":"a","jobtype":"printable_pdf_v2","status":"pending","created_at":"2023-01-01T00:00:00Z"}]`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	e, err := NewReportingExporter("kibana", nil, collector)
+	if err != nil {
+		t.Fatalf("NewReportingExporter failed with valid input")
+	}
+
+	now := time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		ch := make(chan prometheus.Metric, 10)
+		if err := e.update(newScrape(context.Background(), collector, now.Add(offset)), ch); err != nil {
+			t.Fatalf("unexpected update error: %s", err)
+		}
+	}
+
+	// two pages for the first walk and two once the interval is over
+	if requests != 4 {
+		t.Errorf("expected the jobs list to be walked twice, got %d requests", requests)
+	}
+
+	// the age is computed from the cached jobs on every scrape
+	if v := testutil.ToFloat64(e.oldestPendingAge); v != 120 {
+		t.Errorf("expected oldest pending age 120, got %f", v)
+	}
+}
@@ -0,0 +1,158 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Kibana collects the process metrics on an interval (ops.interval,
+	// 5s by default), so the start time calculated from the uptime moves
+	// around by up to that much between observations
+	restartTolerance = 10 * time.Second
+)
+
+func init() {
+	registerCollector("process", "Kibana process uptime, memory usage, event loop delay, and restarts", true, newProcessCollector)
+}
+
+// restartTracker detects Kibana restarts by calculating the process start
+// time from the uptime reported on each observation.
+type restartTracker struct {
+	lock sync.Mutex
+
+	// uuid, start time, and the last observation time of the Kibana
+	// process observed last
+	uuid      string
+	startTime time.Time
+	lastSeen  time.Time
+
+	// metrics
+	restarts       prometheus.Counter
+	startTimeGauge prometheus.Gauge
+}
+
+func newRestartTracker(namespace string, constLabels prometheus.Labels) *restartTracker {
+	return &restartTracker{
+		restarts: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name:        "process_restarts_total",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana process restarts observed by the exporter",
+			}),
+		startTimeGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "process_start_time_seconds",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Start time of the Kibana process since unix epoch in seconds, calculated from the uptime",
+			}),
+	}
+}
+
+// observe will record the Kibana process details from the KibanaMetrics
+// struct, observed at the given time. Stale statuses are ignored, since
+// the start time would be calculated from an earlier uptime.
+func (r *restartTracker) observe(m *KibanaMetrics, now time.Time) {
+	if m.Stale {
+		return
+	}
+
+	uptime := time.Duration(m.Metrics.Process.UptimeInMillis * float64(time.Millisecond))
+	if uptime <= 0 {
+		// the uptime wasn't reported, the start time can't be calculated
+		return
+	}
+
+	startTime := now.Add(-uptime)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch {
+	case r.lastSeen.IsZero():
+		log.Debug().
+			Msgf("kibana process %s started at %s", m.UUID, startTime)
+	case m.UUID == r.uuid:
+		if !startTime.After(r.startTime.Add(restartTolerance)) {
+			// same process, keep the first calculated start time so that
+			// the exported start time doesn't jitter
+			startTime = r.startTime
+			break
+		}
+
+		log.Info().
+			Msgf("kibana process %s restarted at %s", m.UUID, startTime)
+		r.restarts.Inc()
+	default:
+		// a different UUID could be a restart with a new data directory,
+		// or a different node behind a load balancer. It can only be a
+		// restart if the new process started after the last time the
+		// previous process was seen.
+		if startTime.After(r.lastSeen) {
+			log.Info().
+				Msgf("kibana process %s replaced by %s at %s", r.uuid, m.UUID, startTime)
+			r.restarts.Inc()
+		}
+	}
+
+	r.uuid = m.UUID
+	r.startTime = startTime
+	r.lastSeen = now
+	r.startTimeGauge.Set(float64(startTime.UnixNano()) / float64(time.Second))
+}
+
+func (r *restartTracker) describe(ch chan<- *prometheus.Desc) {
+	ch <- r.restarts.Desc()
+	ch <- r.startTimeGauge.Desc()
+}
+
+func (r *restartTracker) collect(ch chan<- prometheus.Metric) {
+	ch <- r.restarts
+	ch <- r.startTimeGauge
+}
+
+// processCollector exports the Kibana process gauges in
+// metricDefinitions, and the restarts detected from the uptime.
+type processCollector struct {
+	*gaugesCollector
+	restarts *restartTracker
+}
+
+func newProcessCollector(s *collectorSettings) (subCollector, error) {
+	gauges, err := newGaugesCollectorFor("process", s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &processCollector{
+		gaugesCollector: gauges,
+		restarts:        newRestartTracker(s.namespace, s.constLabels),
+	}, nil
+}
+
+func (c *processCollector) observe(m *KibanaMetrics, now time.Time) {
+	c.restarts.observe(m, now)
+}
+
+// Describe is the processCollector implementing subCollector
+func (c *processCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.gaugesCollector.Describe(ch)
+	c.restarts.describe(ch)
+}
+
+func (c *processCollector) update(s *scrape, ch chan<- prometheus.Metric) error {
+	m, err := s.status()
+	if err != nil {
+		return err
+	}
+
+	c.observe(m, s.now)
+	c.restarts.collect(ch)
+
+	return c.gaugesCollector.update(s, ch)
+}
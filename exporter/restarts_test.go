@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func processWithUptime(uuid string, uptime time.Duration) *KibanaMetrics {
+	m := &KibanaMetrics{UUID: uuid}
+	m.Metrics.Process.UptimeInMillis = float64(uptime.Milliseconds())
+	return m
+}
+
+var restartTests = []struct {
+	desc         string
+	observations []*KibanaMetrics
+	restarts     float64
+	startTime    float64
+}{
+	{
+		desc: "no restart",
+		observations: []*KibanaMetrics{
+			processWithUptime("a", 100*time.Second),
+			processWithUptime("a", 160*time.Second),
+			// ops metrics collected before the previous observation
+			processWithUptime("a", 217*time.Second),
+		},
+		restarts:  0,
+		startTime: 900,
+	},
+	{
+		desc: "restart with the same uuid",
+		observations: []*KibanaMetrics{
+			processWithUptime("a", 100*time.Second),
+			processWithUptime("a", 20*time.Second),
+		},
+		restarts:  1,
+		startTime: 1040,
+	},
+	{
+		desc: "restart with a new uuid",
+		observations: []*KibanaMetrics{
+			processWithUptime("a", 100*time.Second),
+			processWithUptime("b", 20*time.Second),
+		},
+		restarts:  1,
+		startTime: 1040,
+	},
+	{
+		desc: "a different node behind a load balancer",
+		observations: []*KibanaMetrics{
+			processWithUptime("a", 100*time.Second),
+			processWithUptime("b", 1000*time.Second),
+			processWithUptime("a", 220*time.Second),
+		},
+		restarts:  0,
+		startTime: 900,
+	},
+	{
+		desc: "missing uptime",
+		observations: []*KibanaMetrics{
+			processWithUptime("a", 100*time.Second),
+			processWithUptime("a", 0),
+		},
+		restarts:  0,
+		startTime: 900,
+	},
+}
+
+func TestRestartTrackerObserve(t *testing.T) {
+	for _, rt := range restartTests {
+		t.Run(rt.desc, func(t *testing.T) {
+			r := newRestartTracker("kibana", nil)
+
+			// an observation every minute, starting at 1000
+			now := time.Unix(1000, 0)
+			for _, m := range rt.observations {
+				r.observe(m, now)
+				now = now.Add(time.Minute)
+			}
+
+			if v := testutil.ToFloat64(r.restarts); v != rt.restarts {
+				t.Errorf("expected %f restarts, got %f", rt.restarts, v)
+			}
+
+			if v := testutil.ToFloat64(r.startTimeGauge); v != rt.startTime {
+				t.Errorf("expected start time %f, got %f", rt.startTime, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// statusSchema describes the fields of the Kibana status response for a
+// major version. Fields are dot separated paths, where * matches any
+// single key.
+type statusSchema struct {
+	// expected are the fields read by the exporter, reported as missing
+	// when they are not in the response
+	expected []string
+
+	// known are the fields that are not read by the exporter, but are
+	// not reported as unknown. A known field also covers all the fields
+	// under it.
+	known []string
+}
+
+var (
+	// statusExpectedFields are the fields KibanaMetrics is unmarshalled
+	// from
+	statusExpectedFields = []string{
+		"name",
+		"uuid",
+		"version.number",
+		"status.overall.level",
+		"status.core.elasticsearch.level",
+		"status.core.savedObjects.level",
+		"metrics.concurrent_connections",
+		"metrics.process.uptime_in_millis",
+		"metrics.process.memory.heap.total_in_bytes",
+		"metrics.process.memory.heap.used_in_bytes",
+		"metrics.process.memory.resident_set_size_in_bytes",
+		"metrics.process.event_loop_delay",
+		"metrics.os.load.1m",
+		"metrics.os.load.5m",
+		"metrics.os.load.15m",
+		"metrics.os.memory.total_in_bytes",
+		"metrics.os.memory.used_in_bytes",
+		"metrics.response_times.avg_in_millis",
+		"metrics.response_times.max_in_millis",
+		"metrics.requests.disconnects",
+		"metrics.requests.total",
+	}
+
+	// statusKnownFields are in the status response of all the supported
+	// versions, but are not read by the exporter
+	statusKnownFields = []string{
+		"version.build_hash",
+		"version.build_number",
+		"version.build_snapshot",
+		"status.overall.summary",
+		"status.overall.detail",
+		"status.overall.meta",
+		"status.overall.documentationUrl",
+		"status.core.*.level",
+		"status.core.*.summary",
+		"status.core.*.detail",
+		"status.core.*.meta",
+		"status.core.*.documentationUrl",
+		"status.plugins",
+		"metrics.last_updated",
+		"metrics.collection_interval_in_millis",
+		"metrics.process.pid",
+		"metrics.process.event_loop_delay_histogram",
+		"metrics.process.memory.heap.size_limit",
+		"metrics.os.platform",
+		"metrics.os.platformRelease",
+		"metrics.os.distro",
+		"metrics.os.distroRelease",
+		"metrics.os.uptime_in_millis",
+		"metrics.os.memory.free_in_bytes",
+		"metrics.os.cpu",
+		"metrics.os.cpuacct",
+		"metrics.os.cgroup_memory",
+		"metrics.requests.statusCodes",
+	}
+
+	// statusSchemas are the schemas by Kibana major version
+	statusSchemas = map[int]*statusSchema{
+		7: {
+			expected: statusExpectedFields,
+			known:    statusKnownFields,
+		},
+		8: {
+			expected: statusExpectedFields,
+			known: append([]string{
+				// added in 8.x
+				"version.build_flavor",
+				"version.build_date",
+				"metrics.processes",
+				"metrics.process.event_loop_utilization",
+				"metrics.process.memory.array_buffers_in_bytes",
+				"metrics.process.memory.external_in_bytes",
+				"metrics.elasticsearch_client",
+			}, statusKnownFields...),
+		},
+	}
+
+	// latestSchemaVersion is used for versions newer than the known ones
+	latestSchemaVersion = 8
+)
+
+func init() {
+	registerCollector("schema", "Kibana status API fields that are unknown to the exporter or missing", true, newSchemaCollector)
+}
+
+// schemaFor returns the schema of the given Kibana version, or the
+// closest known one.
+func schemaFor(version string) (*statusSchema, int) {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil || major > latestSchemaVersion {
+		return statusSchemas[latestSchemaVersion], latestSchemaVersion
+	}
+
+	if schema, ok := statusSchemas[major]; ok {
+		return schema, major
+	}
+
+	// older than the oldest known version
+	oldest := latestSchemaVersion
+	for v := range statusSchemas {
+		if v < oldest {
+			oldest = v
+		}
+	}
+
+	return statusSchemas[oldest], oldest
+}
+
+// matchField returns whether the pattern matches the path, or a parent of
+// the path if prefix is true.
+func matchField(pattern, path []string, prefix bool) bool {
+	if len(pattern) > len(path) || (!prefix && len(pattern) != len(path)) {
+		return false
+	}
+
+	for i, segment := range pattern {
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// flattenFields appends the paths of all the leaf fields in the JSON
+// value. Arrays are leaves, since their elements can't be matched to a
+// schema by position.
+func flattenFields(prefix []string, value interface{}, fields [][]string) [][]string {
+	obj, ok := value.(map[string]interface{})
+	if !ok || (len(obj) == 0 && len(prefix) > 0) {
+		return append(fields, prefix)
+	}
+
+	for key, v := range obj {
+		path := make([]string, len(prefix), len(prefix)+1)
+		copy(path, prefix)
+		fields = flattenFields(append(path, key), v, fields)
+	}
+
+	return fields
+}
+
+// lookupField returns whether the field is in the JSON value, and is not
+// null.
+func lookupField(value interface{}, path []string) bool {
+	for _, key := range path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		value, ok = obj[key]
+		if !ok {
+			return false
+		}
+	}
+
+	return value != nil
+}
+
+// schemaDrift is the result of comparing a status response with the
+// schema.
+type schemaDrift struct {
+	unknown []string
+	missing []string
+}
+
+// compare will return the fields in the raw status response that are not
+// in the schema, and the expected fields that are not in the response.
+func (s *statusSchema) compare(raw []byte) (*schemaDrift, error) {
+	var payload interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("error while unmarshalling Kibana status for schema comparison: %s", err)
+	}
+
+	drift := &schemaDrift{}
+	for _, field := range flattenFields(nil, payload, nil) {
+		if !s.covers(field) {
+			drift.unknown = append(drift.unknown, strings.Join(field, "."))
+		}
+	}
+
+	for _, field := range s.expected {
+		if !lookupField(payload, strings.Split(field, ".")) {
+			drift.missing = append(drift.missing, field)
+		}
+	}
+
+	sort.Strings(drift.unknown)
+	return drift, nil
+}
+
+// covers returns whether the field is expected, known, or under a known
+// field.
+func (s *statusSchema) covers(field []string) bool {
+	for _, expected := range s.expected {
+		if matchField(strings.Split(expected, "."), field, false) {
+			return true
+		}
+	}
+
+	for _, known := range s.known {
+		if matchField(strings.Split(known, "."), field, true) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// schemaCollector compares the Kibana status responses with the schema of
+// the Kibana version, to detect API changes the exporter does not handle.
+type schemaCollector struct {
+	lock sync.Mutex
+
+	// last reported drift, to only log changes
+	lastDrift string
+
+	// metrics
+	unknown *prometheus.Desc
+	missing *prometheus.Desc
+}
+
+func newSchemaCollector(s *collectorSettings) (subCollector, error) {
+	return &schemaCollector{
+		unknown: prometheus.NewDesc(
+			prometheus.BuildFQName(s.namespace, "exporter", "schema_unknown_fields"),
+			"Number of fields in the Kibana status response that are unknown to the exporter for the Kibana version",
+			nil,
+			s.constLabels),
+		missing: prometheus.NewDesc(
+			prometheus.BuildFQName(s.namespace, "exporter", "schema_missing_fields"),
+			"Whether each field read by the exporter is missing in the Kibana status response",
+			[]string{"field"},
+			s.constLabels),
+	}, nil
+}
+
+// logDrift will log the drift as a warning, if it is different from the
+// last one logged.
+func (c *schemaCollector) logDrift(drift *schemaDrift, version string, schemaVersion int) {
+	summary := fmt.Sprintf("unknown: %v, missing: %v", drift.unknown, drift.missing)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if summary == c.lastDrift {
+		return
+	}
+
+	c.lastDrift = summary
+	if len(drift.unknown) == 0 && len(drift.missing) == 0 {
+		log.Info().
+			Msgf("kibana %s status response matches the %d.x schema", version, schemaVersion)
+		return
+	}
+
+	log.Warn().
+		Msgf("kibana %s status response differs from the %d.x schema, the exporter may need updating, %s", version, schemaVersion, summary)
+}
+
+// Describe is the schemaCollector implementing subCollector
+func (c *schemaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.unknown
+	ch <- c.missing
+}
+
+func (c *schemaCollector) update(s *scrape, ch chan<- prometheus.Metric) error {
+	m, err := s.status()
+	if err != nil {
+		return err
+	}
+
+	schema, schemaVersion := schemaFor(m.Version.Number)
+	drift, err := schema.compare(m.raw)
+	if err != nil {
+		return err
+	}
+
+	c.logDrift(drift, m.Version.Number, schemaVersion)
+
+	ch <- prometheus.MustNewConstMetric(c.unknown, prometheus.GaugeValue, float64(len(drift.unknown)))
+
+	missing := map[string]bool{}
+	for _, field := range drift.missing {
+		missing[field] = true
+	}
+
+	for _, field := range schema.expected {
+		val := 0.0
+		if missing[field] {
+			val = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.missing, prometheus.GaugeValue, val, field)
+	}
+
+	return nil
+}
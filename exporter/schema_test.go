@@ -0,0 +1,140 @@
+package exporter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const schemaTestStatus = `{
+	"name": "kibana",
+	"uuid": "5b2de169-2785-441b-ae8c-186a1936b17d",
+	"version": {"number": "8.7.1", "build_hash": "abc", "build_number": 1, "build_snapshot": false, "build_flavor": "default", "build_date": "2023-04-27"},
+	"status": {
+		"overall": {"level": "available", "summary": "All services are available"},
+		"core": {
+			"elasticsearch": {"level": "available", "summary": "Elasticsearch is available", "meta": {"warningNodes": []}},
+			"savedObjects": {"level": "available", "summary": "SavedObjects service has completed migrations", "meta": {"migratedIndices": {"migrated": 2}}},
+			"http": {"level": "available", "summary": "HTTP is available"}
+		},
+		"plugins": {"alerting": {"level": "available", "summary": "Alerting is available", "reported": true}}
+	},
+	"metrics": {
+		"last_updated": "2023-05-01T00:00:00.000Z",
+		"collection_interval_in_millis": 5000,
+		"os": {
+			"platform": "linux",
+			"load": {"1m": 0.5, "5m": 0.4, "15m": 0.3},
+			"memory": {"total_in_bytes": 100, "free_in_bytes": 40, "used_in_bytes": 60},
+			"cpu": {"cfs_period_micros": 100000}
+		},
+		"process": {
+			"memory": {"heap": {"total_in_bytes": 10, "used_in_bytes": 5, "size_limit": 20}, "resident_set_size_in_bytes": 15, "array_buffers_in_bytes": 1, "external_in_bytes": 2},
+			"pid": 1,
+			"event_loop_delay": 10.5,
+			"event_loop_delay_histogram": {"min": 9, "max": 12, "percentiles": {"50": 10}},
+			"uptime_in_millis": 1000
+		},
+		"processes": [{"pid": 1}],
+		"response_times": {"avg_in_millis": 5, "max_in_millis": 50},
+		"requests": {"disconnects": 0, "total": 10, "statusCodes": {"200": 10}},
+		"concurrent_connections": 1,
+		"elasticsearch_client": {"totalActiveSockets": 1}
+	}
+}`
+
+func TestSchemaFor(t *testing.T) {
+	versions := map[string]int{
+		"8.7.1":     8,
+		"7.17.10":   7,
+		"9.0.0":     8,
+		"6.8.0":     7,
+		"":          8,
+		"snapshot":  8,
+		"8.0.0-rc1": 8,
+	}
+
+	for version, expected := range versions {
+		if _, v := schemaFor(version); v != expected {
+			t.Errorf("expected schema %d for version %q, got %d", expected, version, v)
+		}
+	}
+}
+
+var schemaCompareTests = []struct {
+	desc    string
+	status  string
+	unknown []string
+	missing []string
+}{
+	{
+		desc:   "matching",
+		status: schemaTestStatus,
+	},
+	{
+		desc:    "renamed field",
+		status:  strings.Replace(schemaTestStatus, `"event_loop_delay": 10.5`, `"event_loop_delay_in_millis": 10.5`, 1),
+		unknown: []string{"metrics.process.event_loop_delay_in_millis"},
+		missing: []string{"metrics.process.event_loop_delay"},
+	},
+	{
+		desc:    "null field",
+		status:  strings.Replace(schemaTestStatus, `"concurrent_connections": 1`, `"concurrent_connections": null`, 1),
+		missing: []string{"metrics.concurrent_connections"},
+	},
+	{
+		desc:    "new nested fields",
+		status:  strings.Replace(schemaTestStatus, `"pid": 1,`, `"pid": 1, "gc": {"count": 1, "pause_in_millis": 2},`, 1),
+		unknown: []string{"metrics.process.gc.count", "metrics.process.gc.pause_in_millis"},
+	},
+}
+
+func TestStatusSchemaCompare(t *testing.T) {
+	schema, _ := schemaFor("8.7.1")
+	for _, st := range schemaCompareTests {
+		t.Run(st.desc, func(t *testing.T) {
+			drift, err := schema.compare([]byte(st.status))
+			if err != nil {
+				t.Fatalf("unexpected error while comparing the schema: %s", err)
+			}
+
+			if !reflect.DeepEqual(drift.unknown, st.unknown) {
+				t.Errorf("expected unknown fields %v, got %v", st.unknown, drift.unknown)
+			}
+
+			if !reflect.DeepEqual(drift.missing, st.missing) {
+				t.Errorf("expected missing fields %v, got %v", st.missing, drift.missing)
+			}
+		})
+	}
+}
+
+func TestSchemaCollectorUpdate(t *testing.T) {
+	c, err := newSchemaCollector(&collectorSettings{namespace: "kibana"})
+	if err != nil {
+		t.Fatalf("newSchemaCollector failed with valid input")
+	}
+
+	m := &KibanaMetrics{raw: []byte(strings.Replace(schemaTestStatus, `"uuid": "5b2de169-2785-441b-ae8c-186a1936b17d",`, `"node_id": "5b2de169",`, 1))}
+	m.Version.Number = "8.7.1"
+
+	s := newScrape(context.Background(), nil, time.Now())
+	s.once.Do(func() { s.metrics = m })
+
+	expected := `
+# HELP kibana_exporter_schema_unknown_fields Number of fields in the Kibana status response that are unknown to the exporter for the Kibana version
+# TYPE kibana_exporter_schema_unknown_fields gauge
+kibana_exporter_schema_unknown_fields 1
+`
+	if err := testutil.CollectAndCompare(testCollector{subCollector: c, s: s}, strings.NewReader(expected), "kibana_exporter_schema_unknown_fields"); err != nil {
+		t.Errorf("unexpected unknown fields output: %s", err)
+	}
+
+	if count := testutil.CollectAndCount(testCollector{subCollector: c, s: s}, "kibana_exporter_schema_missing_fields"); count != len(statusExpectedFields) {
+		t.Errorf("expected a missing fields series for each expected field, got %d", count)
+	}
+}
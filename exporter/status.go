@@ -0,0 +1,192 @@
+package exporter
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// https://github.com/elastic/kibana/blob/12466d8b17d8557ff0b561c346511bd1760da4c1/packages/core/status/core-status-common/src/service_status.ts
+	statusLevels = map[string]float64{
+		// everything is working
+		"available": 1,
+		// some features may not be working
+		"degraded": 0.5,
+		// the service is unavailble, but other functions that do not depend on this service should work.
+		"unavailable": 0.25,
+		// block all user functions and display the status page, reserved for Core services only.
+		"critical": 0,
+	}
+
+	// all the possible values of the level label of the status level
+	// metric, unrecognized levels such as initializing are unknown
+	statusLevelNames = []string{"available", "degraded", "unavailable", "critical", statusLevelUnknown}
+)
+
+func init() {
+	registerCollector("status", "Kibana overall and core service status levels", true, newStatusCollector)
+}
+
+// normalizeStatusLevel returns the lower cased status level, and its
+// float encoding. Unrecognized levels are returned as unknown.
+func normalizeStatusLevel(level string) (string, float64) {
+	level = strings.ToLower(level)
+
+	val, ok := statusLevels[level]
+	if !ok {
+		// absence of this metric will default to critical in the legacy
+		// gauge, initialising is also considered 0
+		return statusLevelUnknown, 0
+	}
+
+	return level, val
+}
+
+// setStatusLevel sets the StateSet of a status level, 1 for the given
+// level and 0 for the others.
+func setStatusLevel(g *prometheus.GaugeVec, name, level string) {
+	for _, l := range statusLevelNames {
+		if l == level {
+			g.WithLabelValues(name, l).Set(1)
+		} else {
+			g.WithLabelValues(name, l).Set(0)
+		}
+	}
+}
+
+// statusCollector exports the status levels of Kibana and its core
+// services, and tracks the transitions between them.
+type statusCollector struct {
+	history *statusHistory
+
+	// legacyStatus is whether to export the float encoded status gauges
+	legacyStatus bool
+
+	// metrics
+	statusLevel  *prometheus.GaugeVec
+	statusInfo   *prometheus.GaugeVec
+	responseCode prometheus.Gauge
+	status       prometheus.Gauge
+	coreESStatus prometheus.Gauge
+	coreSOStatus prometheus.Gauge
+}
+
+func newStatusCollector(s *collectorSettings) (subCollector, error) {
+	return &statusCollector{
+		history:      newStatusHistory(s.namespace, s.constLabels),
+		legacyStatus: s.legacyStatus,
+
+		statusLevel: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "status_level",
+				Help:        "Kibana status level by service, 1 for the current level and 0 for the others",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			},
+			[]string{"service", "level"}),
+		statusInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "status_info",
+				Help:        "Kibana status level and summary by service, always 1",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			},
+			[]string{"service", "level", "summary"}),
+		responseCode: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "status_response_code",
+				Help:        "HTTP response code of the Kibana status API, 503 when the overall status is unavailable or critical",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			}),
+		status: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "status",
+				Help:        "Kibana overall status",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			}),
+		coreESStatus: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "core_es_status",
+				Help:        "Kibana Elasticsearch connectivity status",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			}),
+		coreSOStatus: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "core_savedobjects_status",
+				Help:        "Kibana SavedObjects service status",
+				Namespace:   s.namespace,
+				ConstLabels: s.constLabels,
+			}),
+	}, nil
+}
+
+// parseMetrics will set the status metrics values using the
+// KibanaMetrics struct.
+func (c *statusCollector) parseMetrics(m *KibanaMetrics) {
+	log.Trace().
+		Msg("parsing received status from kibana")
+
+	c.statusInfo.Reset()
+	c.setStatus(statusServiceOverall, m.Status.Overall, c.status)
+	c.setStatus(statusServiceElasticsearch, m.Status.Core.Elasticsearch, c.coreESStatus)
+	c.setStatus(statusServiceSavedObjects, m.Status.Core.SavedObjects, c.coreSOStatus)
+
+	c.responseCode.Set(float64(m.ResponseCode))
+}
+
+// setStatus will set the status level metrics of a single service, and
+// the given legacy gauge.
+func (c *statusCollector) setStatus(service string, status ServiceStatus, legacy prometheus.Gauge) {
+	level, val := normalizeStatusLevel(status.Level)
+
+	legacy.Set(val)
+	setStatusLevel(c.statusLevel, service, level)
+	c.statusInfo.WithLabelValues(service, level, status.Summary).Set(1)
+}
+
+func (c *statusCollector) observe(m *KibanaMetrics, now time.Time) {
+	c.history.observe(m, now)
+}
+
+// Describe is the statusCollector implementing subCollector
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	if c.legacyStatus {
+		ch <- c.status.Desc()
+		ch <- c.coreESStatus.Desc()
+		ch <- c.coreSOStatus.Desc()
+	}
+
+	c.statusLevel.Describe(ch)
+	c.statusInfo.Describe(ch)
+	ch <- c.responseCode.Desc()
+	c.history.describe(ch)
+}
+
+func (c *statusCollector) update(s *scrape, ch chan<- prometheus.Metric) error {
+	m, err := s.status()
+	if err != nil {
+		return err
+	}
+
+	c.parseMetrics(m)
+	c.observe(m, s.now)
+
+	if c.legacyStatus {
+		ch <- c.status
+		ch <- c.coreESStatus
+		ch <- c.coreSOStatus
+	}
+
+	c.statusLevel.Collect(ch)
+	c.statusInfo.Collect(ch)
+	ch <- c.responseCode
+	c.history.collect(ch)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	statusServiceOverall       = "overall"
+	statusServiceElasticsearch = "elasticsearch"
+	statusServiceSavedObjects  = "savedObjects"
+
+	// used when Kibana does not report a level for a service
+	statusLevelUnknown = "unknown"
+)
+
+// statusHistory remembers the last observed status level of each
+// service, to count transitions between levels and the time spent in
+// each level.
+type statusHistory struct {
+	lock sync.Mutex
+
+	// last observed level and observation time per service
+	levels   map[string]string
+	observed map[string]time.Time
+
+	// metrics
+	transitions *prometheus.CounterVec
+	lastChange  *prometheus.GaugeVec
+	levelTime   *prometheus.CounterVec
+}
+
+func newStatusHistory(namespace string, constLabels prometheus.Labels) *statusHistory {
+	return &statusHistory{
+		levels:   map[string]string{},
+		observed: map[string]time.Time{},
+
+		transitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "status_transitions_total",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Kibana status level transition count by service",
+			},
+			[]string{"service", "from", "to"}),
+		lastChange: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "status_last_change_timestamp_seconds",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Time of the last Kibana status level change by service in seconds since epoch, or the first observation",
+			},
+			[]string{"service"}),
+		levelTime: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "status_level_seconds_total",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Cumulative time Kibana services were observed in each status level in seconds",
+			},
+			[]string{"service", "level"}),
+	}
+}
+
+// observe will record the status levels of the services in the
+// KibanaMetrics struct, observed at the given time. Stale statuses are
+// ignored, since they were not observed at that time.
+func (h *statusHistory) observe(m *KibanaMetrics, now time.Time) {
+	if m.Stale {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.observeService(statusServiceOverall, m.Status.Overall.Level, now)
+	h.observeService(statusServiceElasticsearch, m.Status.Core.Elasticsearch.Level, now)
+	h.observeService(statusServiceSavedObjects, m.Status.Core.SavedObjects.Level, now)
+}
+
+func (h *statusHistory) observeService(service, level string, now time.Time) {
+	// the same levels as kibana_service_status, unrecognized levels are
+	// tracked as unknown
+	level, _ = normalizeStatusLevel(level)
+
+	prevLevel, ok := h.levels[service]
+	if !ok {
+		log.Debug().
+			Msgf("first observed status of %s is %s", service, level)
+
+		h.levels[service] = level
+		h.observed[service] = now
+		h.lastChange.WithLabelValues(service).Set(float64(now.Unix()))
+		h.levelTime.WithLabelValues(service, level).Add(0)
+		return
+	}
+
+	// the service is assumed to have stayed in the previous level until
+	// this observation
+	if elapsed := now.Sub(h.observed[service]); elapsed > 0 {
+		h.levelTime.WithLabelValues(service, prevLevel).Add(elapsed.Seconds())
+		h.observed[service] = now
+	}
+
+	if prevLevel == level {
+		return
+	}
+
+	log.Info().
+		Msgf("status of %s changed from %s to %s", service, prevLevel, level)
+
+	h.levels[service] = level
+	h.transitions.WithLabelValues(service, prevLevel, level).Inc()
+	h.lastChange.WithLabelValues(service).Set(float64(now.Unix()))
+	h.levelTime.WithLabelValues(service, level).Add(0)
+}
+
+func (h *statusHistory) describe(ch chan<- *prometheus.Desc) {
+	h.transitions.Describe(ch)
+	h.lastChange.Describe(ch)
+	h.levelTime.Describe(ch)
+}
+
+func (h *statusHistory) collect(ch chan<- prometheus.Metric) {
+	h.transitions.Collect(ch)
+	h.lastChange.Collect(ch)
+	h.levelTime.Collect(ch)
+}
+
+// PollStatus will poll the Kibana status on the given interval, in
+// addition to the scrapes by Prometheus, until the context is cancelled.
+// This only feeds the status history and the restart tracker, so that
+// short status changes between Prometheus scrapes are still counted.
+func (e *Exporter) PollStatus(ctx context.Context, interval time.Duration) {
+	log.Info().
+		Msgf("polling Kibana status every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().
+				Msg("stopping Kibana status polling")
+			return
+		case <-ticker.C:
+		}
+
+		metrics, err := e.collector.scrape(ctx)
+		if err != nil {
+			log.Warn().
+				Msgf("error while polling Kibana status: %s", err)
+			continue
+		}
+
+		e.observe(metrics, time.Now())
+	}
+}
@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func statusWithLevel(level string) *KibanaMetrics {
+	m := &KibanaMetrics{}
+	m.Status.Overall.Level = level
+	m.Status.Core.Elasticsearch.Level = "available"
+	return m
+}
+
+func TestStatusHistoryObserve(t *testing.T) {
+	h := newStatusHistory("kibana", nil)
+	start := time.Unix(1000, 0)
+
+	h.observe(statusWithLevel("available"), start)
+	h.observe(statusWithLevel("degraded"), start.Add(10*time.Second))
+	h.observe(statusWithLevel("degraded"), start.Add(15*time.Second))
+	h.observe(statusWithLevel("available"), start.Add(20*time.Second))
+
+	if v := testutil.ToFloat64(h.transitions.WithLabelValues("overall", "available", "degraded")); v != 1 {
+		t.Errorf("expected 1 available to degraded transition, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(h.transitions.WithLabelValues("overall", "degraded", "available")); v != 1 {
+		t.Errorf("expected 1 degraded to available transition, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(h.lastChange.WithLabelValues("overall")); v != 1020 {
+		t.Errorf("expected last change at 1020, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(h.levelTime.WithLabelValues("overall", "available")); v != 10 {
+		t.Errorf("expected 10s in available, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(h.levelTime.WithLabelValues("overall", "degraded")); v != 10 {
+		t.Errorf("expected 10s in degraded, got %f", v)
+	}
+
+	// a missing level is tracked as unknown
+	if v := testutil.ToFloat64(h.levelTime.WithLabelValues("savedObjects", "unknown")); v != 20 {
+		t.Errorf("expected 20s in unknown for savedObjects, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(h.lastChange.WithLabelValues("elasticsearch")); v != 1000 {
+		t.Errorf("expected last change at the first observation for elasticsearch, got %f", v)
+	}
+}
+
+func TestStatusHistoryUnrecognizedLevel(t *testing.T) {
+	h := newStatusHistory("kibana", nil)
+	start := time.Unix(1000, 0)
+
+	h.observe(statusWithLevel("Available"), start)
+	h.observe(statusWithLevel("initializing"), start.Add(10*time.Second))
+	h.observe(statusWithLevel("starting"), start.Add(20*time.Second))
+
+	if v := testutil.ToFloat64(h.transitions.WithLabelValues("overall", "available", "unknown")); v != 1 {
+		t.Errorf("expected 1 available to unknown transition, got %f", v)
+	}
+
+	if v := testutil.ToFloat64(h.levelTime.WithLabelValues("overall", "unknown")); v != 10 {
+		t.Errorf("expected 10s in unknown, got %f", v)
+	}
+
+	// both unrecognized levels are unknown, without a transition between
+	// them or series of their own
+	if count := testutil.CollectAndCount(h.transitions); count != 1 {
+		t.Errorf("expected a single transition series, got %d", count)
+	}
+
+	// available and unknown for overall, available for elasticsearch, and
+	// unknown for savedObjects
+	if count := testutil.CollectAndCount(h.levelTime); count != 4 {
+		t.Errorf("expected 4 time in level series, got %d", count)
+	}
+}
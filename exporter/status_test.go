@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseMetricsStatusLevel(t *testing.T) {
+	c, err := newStatusCollector(&collectorSettings{namespace: "kibana", legacyStatus: true})
+	if err != nil {
+		t.Fatalf("newStatusCollector failed with valid input")
+	}
+
+	e := c.(*statusCollector)
+	m := &KibanaMetrics{}
+	m.Status.Overall.Level = "critical"
+	m.Status.Overall.Summary = "Elasticsearch is unavailable"
+	m.Status.Core.Elasticsearch.Level = "initializing"
+	m.Status.Core.SavedObjects.Level = "Available"
+
+	e.parseMetrics(m)
+
+	expected := `
+# HELP kibana_status_level Kibana status level by service, 1 for the current level and 0 for the others
+# TYPE kibana_status_level gauge
+kibana_status_level{level="available",service="elasticsearch"} 0
+kibana_status_level{level="available",service="overall"} 0
+kibana_status_level{level="available",service="savedObjects"} 1
+kibana_status_level{level="critical",service="elasticsearch"} 0
+kibana_status_level{level="critical",service="overall"} 1
+kibana_status_level{level="critical",service="savedObjects"} 0
+kibana_status_level{level="degraded",service="elasticsearch"} 0
+kibana_status_level{level="degraded",service="overall"} 0
+kibana_status_level{level="degraded",service="savedObjects"} 0
+kibana_status_level{level="unavailable",service="elasticsearch"} 0
+kibana_status_level{level="unavailable",service="overall"} 0
+kibana_status_level{level="unavailable",service="savedObjects"} 0
+kibana_status_level{level="unknown",service="elasticsearch"} 1
+kibana_status_level{level="unknown",service="overall"} 0
+kibana_status_level{level="unknown",service="savedObjects"} 0
+`
+	if err := testutil.CollectAndCompare(e.statusLevel, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected status level output: %s", err)
+	}
+
+	if v := testutil.ToFloat64(e.statusInfo.WithLabelValues("overall", "critical", "Elasticsearch is unavailable")); v != 1 {
+		t.Errorf("expected status info with the summary, got %f", v)
+	}
+}
+
+func TestStatusCollectorWithoutLegacyStatus(t *testing.T) {
+	c, err := newStatusCollector(&collectorSettings{namespace: "kibana", legacyStatus: false})
+	if err != nil {
+		t.Fatalf("newStatusCollector failed with valid input")
+	}
+
+	e := c.(*statusCollector)
+
+	ch := make(chan *prometheus.Desc, 100)
+	e.Describe(ch)
+	close(ch)
+
+	for desc := range ch {
+		if desc == e.status.Desc() {
+			t.Errorf("legacy status gauge should not be described when disabled")
+		}
+	}
+}
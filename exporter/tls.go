@@ -0,0 +1,187 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// tlsObservation is the certificate chain presented by Kibana on the
+// last request, and the result of verifying it against the system roots.
+type tlsObservation struct {
+	certs     []*x509.Certificate
+	verifyErr error
+
+	// fingerprint is the SHA-256 of the leaf certificate, and expires the
+	// earliest expiry in the chain, the verification result is reused
+	// for the same leaf until then
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+}
+
+// observeTLS will record the certificate chain of a successful TLS
+// connection. The chain is verified here even if the connection itself
+// skipped the verification, to report whether it would have failed. The
+// verification only runs again when Kibana presents a new leaf
+// certificate, or when a certificate of the chain expires.
+func (c *KibanaCollector) observeTLS(state *tls.ConnectionState) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	certs := state.PeerCertificates
+	fingerprint := sha256.Sum256(certs[0].Raw)
+	if last := c.tlsObservation(); last != nil && last.fingerprint == fingerprint && time.Now().Before(last.expires) {
+		return
+	}
+
+	expires := certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(expires) {
+			expires = cert.NotAfter
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       c.hostname(),
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		log.Debug().
+			Msgf("kibana certificate verification failed: %s", err)
+	}
+
+	c.tlsLock.Lock()
+	defer c.tlsLock.Unlock()
+	c.tls = &tlsObservation{certs: certs, verifyErr: err, fingerprint: fingerprint, expires: expires}
+}
+
+// observeTLSError will record the certificate that failed the
+// verification, if the request error was caused by one.
+func (c *KibanaCollector) observeTLSError(err error) {
+	var cert *x509.Certificate
+
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		cert = unknownAuthorityErr.Cert
+	case errors.As(err, &invalidErr):
+		cert = invalidErr.Cert
+	case errors.As(err, &hostnameErr):
+		cert = hostnameErr.Certificate
+	}
+
+	if cert == nil {
+		return
+	}
+
+	c.tlsLock.Lock()
+	defer c.tlsLock.Unlock()
+	c.tls = &tlsObservation{certs: []*x509.Certificate{cert}, verifyErr: err}
+}
+
+// tlsObservation returns the last observed certificate chain, nil if
+// Kibana is not served over TLS or no request has been made yet.
+func (c *KibanaCollector) tlsObservation() *tlsObservation {
+	c.tlsLock.Lock()
+	defer c.tlsLock.Unlock()
+
+	return c.tls
+}
+
+// tlsMetrics builds the metrics for the certificate chain presented by
+// Kibana.
+type tlsMetrics struct {
+	notAfter *prometheus.Desc
+	info     *prometheus.Desc
+	verified *prometheus.Desc
+}
+
+func newTLSMetrics(namespace string, constLabels prometheus.Labels) *tlsMetrics {
+	return &tlsMetrics{
+		notAfter: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "certificate_not_after_seconds"),
+			"Expiry time of the certificates presented by Kibana in seconds since epoch",
+			[]string{"subject", "issuer", "serial_number"},
+			constLabels),
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "certificate_info"),
+			"Details of the leaf certificate presented by Kibana, always 1",
+			[]string{"subject", "issuer", "serial_number", "dns_names", "ip_addresses"},
+			constLabels),
+		verified: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tls", "chain_verified"),
+			"Whether the certificate chain presented by Kibana is trusted, reported even when TLS verification is skipped",
+			nil,
+			constLabels),
+	}
+}
+
+func (t *tlsMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- t.notAfter
+	ch <- t.info
+	ch <- t.verified
+}
+
+func (t *tlsMetrics) collect(ch chan<- prometheus.Metric, o *tlsObservation) {
+	if o == nil {
+		return
+	}
+
+	// misconfigured servers can send the same certificate more than once
+	// in the chain, which would be a duplicate series
+	seen := map[string]bool{}
+	for _, cert := range o.certs {
+		key := strings.Join([]string{cert.Subject.String(), cert.Issuer.String(), cert.SerialNumber.String()}, "\xff")
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		ch <- prometheus.MustNewConstMetric(
+			t.notAfter,
+			prometheus.GaugeValue,
+			float64(cert.NotAfter.Unix()),
+			cert.Subject.String(),
+			cert.Issuer.String(),
+			cert.SerialNumber.String(),
+		)
+	}
+
+	leaf := o.certs[0]
+	ips := make([]string, 0, len(leaf.IPAddresses))
+	for _, ip := range leaf.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		t.info,
+		prometheus.GaugeValue,
+		1,
+		leaf.Subject.String(),
+		leaf.Issuer.String(),
+		leaf.SerialNumber.String(),
+		strings.Join(leaf.DNSNames, ","),
+		strings.Join(ips, ","),
+	)
+
+	verified := 1.0
+	if o.verifyErr != nil {
+		verified = 0
+	}
+
+	ch <- prometheus.MustNewConstMetric(t.verified, prometheus.GaugeValue, verified)
+}
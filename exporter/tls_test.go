@@ -0,0 +1,183 @@
+package exporter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// tlsTestCollector implements prometheus.Collector to test tlsMetrics
+// in isolation from the rest of the Exporter metrics.
+type tlsTestCollector struct {
+	metrics   *tlsMetrics
+	collector *KibanaCollector
+}
+
+func (c tlsTestCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.metrics.describe(ch)
+}
+
+func (c tlsTestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.collect(ch, c.collector.tlsObservation())
+}
+
+var tlsTests = []struct {
+	desc    string
+	skipTLS bool
+	// not_after for the single self signed certificate, certificate_info
+	// for the leaf, and chain_verified
+	count int
+}{
+	{
+		desc:    "verification skipped",
+		skipTLS: true,
+		count:   3,
+	},
+	{
+		desc:    "verification failed",
+		skipTLS: false,
+		count:   3,
+	},
+}
+
+func TestTLSMetricsUntrustedCertificate(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	for _, tt := range tlsTests {
+		t.Run(tt.desc, func(t *testing.T) {
+			collector, err := NewCollector(ts.URL, "", "", tt.skipTLS)
+			if err != nil {
+				t.Fatalf("NewCollector failed with valid input")
+			}
+
+			_, err = collector.request(context.Background(), http.MethodGet, "/", nil)
+			if tt.skipTLS == (err != nil) {
+				t.Fatalf("unexpected request result with skipTLS=%t: %v", tt.skipTLS, err)
+			}
+
+			c := tlsTestCollector{metrics: newTLSMetrics("kibana", nil), collector: collector}
+			if count := testutil.CollectAndCount(c); count != tt.count {
+				t.Errorf("expected %d TLS series, got %d", tt.count, count)
+			}
+
+			// the httptest certificate is self signed
+			expected := `
+# HELP kibana_tls_chain_verified Whether the certificate chain presented by Kibana is trusted, reported even when TLS verification is skipped
+# TYPE kibana_tls_chain_verified gauge
+kibana_tls_chain_verified 0
+`
+			if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "kibana_tls_chain_verified"); err != nil {
+				t.Errorf("unexpected chain verification result: %s", err)
+			}
+		})
+	}
+}
+
+func TestTLSMetricsPlainText(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	_, err = collector.request(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatalf("unexpected request error: %s", err)
+	}
+
+	c := tlsTestCollector{metrics: newTLSMetrics("kibana", nil), collector: collector}
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Errorf("expected no TLS series for a plain text URL, got %d", count)
+	}
+}
+
+func TestTLSMetricsDuplicateCertificate(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", true)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	// the same certificate sent twice in the chain
+	collector.observeTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{ts.Certificate(), ts.Certificate()}})
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(tlsTestCollector{metrics: newTLSMetrics("kibana", nil), collector: collector})
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected gather error: %s", err)
+	}
+
+	for _, mf := range families {
+		if mf.GetName() == "kibana_tls_certificate_not_after_seconds" && len(mf.GetMetric()) != 1 {
+			t.Errorf("expected a single not after series, got %d", len(mf.GetMetric()))
+		}
+	}
+}
+
+func TestObserveTLSCachesVerification(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", true)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{ts.Certificate()}}
+	collector.observeTLS(state)
+	first := collector.tlsObservation()
+
+	// the same leaf certificate is not verified again
+	collector.observeTLS(state)
+	if collector.tlsObservation() != first {
+		t.Errorf("expected the verification of the same certificate to be reused")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate a key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kibana"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create a certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("could not parse the certificate: %s", err)
+	}
+
+	// a new leaf certificate is verified
+	collector.observeTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	if o := collector.tlsObservation(); o == first || o.certs[0] != cert {
+		t.Errorf("expected a new leaf certificate to be verified")
+	}
+}
@@ -0,0 +1,221 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// https://www.elastic.co/guide/en/kibana/8.7/upgrade-assistant-api-status.html
+	upgradeStatusPath = "/api/upgrade_assistant/status"
+	// used by the Upgrade Assistant UI to list Elasticsearch deprecations
+	upgradeESDeprecationsPath = "/api/upgrade_assistant/es_deprecations"
+	// used by the Upgrade Assistant UI to list Kibana deprecations
+	kibanaDeprecationsPath = "/api/deprecations/"
+
+	deprecationSourceElasticsearch = "elasticsearch"
+	deprecationSourceKibana        = "kibana"
+)
+
+var (
+	// deprecation levels that are always exported, even when there are
+	// no deprecations at that level, so that queries don't return empty
+	deprecationLevels = []string{"critical", "warning"}
+)
+
+func init() {
+	registerCollector("upgrade-assistant", "Kibana Upgrade Assistant readiness", false, func(s *collectorSettings) (subCollector, error) {
+		return NewUpgradeExporter(s.namespace, s.constLabels, s.collector)
+	})
+}
+
+// Deprecation is used to unmarshal a single deprecation entry from the
+// Upgrade Assistant and deprecations APIs.
+type Deprecation struct {
+	Level string `json:"level"`
+}
+
+// UpgradeStatus is used to unmarshal the Upgrade Assistant status
+// response from Kibana.
+type UpgradeStatus struct {
+	ReadyForUpgrade bool `json:"readyForUpgrade"`
+
+	// only returned by Kibana 7.x, 8.x moved these to the
+	// es_deprecations API
+	Cluster []Deprecation `json:"cluster"`
+	Indices []Deprecation `json:"indices"`
+}
+
+// UpgradeExporter is the upgrade-assistant collector, exporting the
+// Kibana Upgrade Assistant readiness.
+type UpgradeExporter struct {
+	lock      sync.Mutex
+	collector *KibanaCollector
+
+	// metrics
+	ready        prometheus.Gauge
+	deprecations *prometheus.GaugeVec
+}
+
+// NewUpgradeExporter will create an UpgradeExporter struct and
+// initialize the Upgrade Assistant metrics.
+func NewUpgradeExporter(namespace string, constLabels prometheus.Labels, collector *KibanaCollector) (*UpgradeExporter, error) {
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" {
+		return nil, errors.New("namespace cannot be empty")
+	}
+
+	exporter := &UpgradeExporter{
+		collector: collector,
+
+		ready: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name:        "upgrade_ready_for_upgrade",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Whether the Upgrade Assistant reports the stack as ready for the next major upgrade",
+			}),
+		deprecations: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:        "upgrade_deprecations",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Help:        "Upgrade Assistant deprecation count by source and level",
+			},
+			[]string{"source", "level"}),
+	}
+
+	return exporter, nil
+}
+
+// scrapeUpgradeStatus will return the Upgrade Assistant status.
+func (c *KibanaCollector) scrapeUpgradeStatus(ctx context.Context) (*UpgradeStatus, error) {
+	respContent, err := c.request(ctx, http.MethodGet, upgradeStatusPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading Upgrade Assistant status: %s", err)
+	}
+
+	status := &UpgradeStatus{}
+	err = json.Unmarshal(respContent, status)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling Upgrade Assistant status: %s\nProblematic content:\n%s", err, respContent)
+	}
+
+	return status, nil
+}
+
+// ESDeprecations is used to unmarshal the Elasticsearch deprecations
+// response of the Upgrade Assistant from Kibana 8.x.
+type ESDeprecations struct {
+	TotalCriticalDeprecations int `json:"totalCriticalDeprecations"`
+
+	// returned by Kibana 8.13 and later, along with the health indicators
+	MigrationsDeprecations []Deprecation `json:"migrationsDeprecations"`
+	// returned by Kibana 8.0 to 8.12
+	Deprecations []Deprecation `json:"deprecations"`
+}
+
+// scrapeESDeprecations will return the Elasticsearch deprecations listed
+// by the Upgrade Assistant.
+func (c *KibanaCollector) scrapeESDeprecations(ctx context.Context) (*ESDeprecations, error) {
+	respContent, err := c.request(ctx, http.MethodGet, upgradeESDeprecationsPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading Elasticsearch deprecations: %s", err)
+	}
+
+	deprecations := &ESDeprecations{}
+	err = json.Unmarshal(respContent, deprecations)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling Elasticsearch deprecations: %s\nProblematic content:\n%s", err, respContent)
+	}
+
+	if deprecations.MigrationsDeprecations == nil {
+		deprecations.MigrationsDeprecations = deprecations.Deprecations
+	}
+
+	return deprecations, nil
+}
+
+// scrapeKibanaDeprecations will return the list of Kibana deprecations.
+func (c *KibanaCollector) scrapeKibanaDeprecations(ctx context.Context) ([]Deprecation, error) {
+	respContent, err := c.request(ctx, http.MethodGet, kibanaDeprecationsPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading deprecations: %s", err)
+	}
+
+	resp := struct {
+		Deprecations []Deprecation `json:"deprecations"`
+	}{}
+	err = json.Unmarshal(respContent, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling deprecations: %s\nProblematic content:\n%s", err, respContent)
+	}
+
+	return resp.Deprecations, nil
+}
+
+// setDeprecations will set the deprecation counts for the given source.
+func (e *UpgradeExporter) setDeprecations(source string, deprecations []Deprecation) {
+	for _, level := range deprecationLevels {
+		e.deprecations.WithLabelValues(source, level).Set(0)
+	}
+
+	for _, d := range deprecations {
+		e.deprecations.WithLabelValues(source, strings.ToLower(d.Level)).Inc()
+	}
+}
+
+// Describe is the UpgradeExporter implementing subCollector
+func (e *UpgradeExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.ready.Desc()
+	e.deprecations.Describe(ch)
+}
+
+// update will export the readiness, and the deprecations that could be
+// read. The error of the last deprecations API that failed is returned.
+func (e *UpgradeExporter) update(s *scrape, ch chan<- prometheus.Metric) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	status, err := e.collector.scrapeUpgradeStatus(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.ReadyForUpgrade {
+		e.ready.Set(1)
+	} else {
+		e.ready.Set(0)
+	}
+
+	e.deprecations.Reset()
+
+	if status.Cluster != nil || status.Indices != nil {
+		// 7.x returns the Elasticsearch deprecations with the status
+		e.setDeprecations(deprecationSourceElasticsearch, append(status.Cluster, status.Indices...))
+	} else if esDeprecations, esErr := e.collector.scrapeESDeprecations(s.ctx); esErr != nil {
+		err = esErr
+	} else {
+		e.setDeprecations(deprecationSourceElasticsearch, esDeprecations.MigrationsDeprecations)
+		// the total is what the Upgrade Assistant blocks the upgrade on
+		e.deprecations.WithLabelValues(deprecationSourceElasticsearch, "critical").Set(float64(esDeprecations.TotalCriticalDeprecations))
+	}
+
+	if kibanaDeprecations, kibanaErr := e.collector.scrapeKibanaDeprecations(s.ctx); kibanaErr != nil {
+		err = kibanaErr
+	} else {
+		e.setDeprecations(deprecationSourceKibana, kibanaDeprecations)
+	}
+
+	ch <- e.ready
+	e.deprecations.Collect(ch)
+
+	return err
+}
@@ -0,0 +1,131 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// upgradeTestESDeprecations follows the es_deprecations response of Kibana
+// 8.13 and later
+const upgradeTestESDeprecations = `{
+  "totalCriticalDeprecations": 1,
+  "migrationsDeprecations": [
+    {
+      "type": "index_settings",
+      "level": "critical",
+      "resolveDuringUpgrade": false,
+      "url": "https://www.elastic.co/guide/en/elasticsearch/reference/current/migrating-8.0.html#breaking-changes-8.0",
+      "message": "Index created before 7.0",
+      "details": "This index was created using version: 6.8.13",
+      "index": "logs-2019.01",
+      "correctiveAction": {
+        "type": "reindex"
+      }
+    },
+    {
+      "type": "cluster_settings",
+      "level": "warning",
+      "resolveDuringUpgrade": false,
+      "url": "https://ela.st/es-deprecation-8-transient-cluster-settings",
+      "message": "Transient cluster settings are deprecated",
+      "details": "Use persistent settings to configure your cluster.",
+      "correctiveAction": {
+        "type": "clusterSetting",
+        "deprecatedSettings": ["cluster.routing.allocation.enable"]
+      }
+    },
+    {
+      "type": "node_settings",
+      "level": "warning",
+      "resolveDuringUpgrade": false,
+      "url": "https://ela.st/es-deprecation-7-monitoring-settings",
+      "message": "Setting [xpack.monitoring.collection.enabled] is deprecated",
+      "details": "Remove the [xpack.monitoring.collection.enabled] setting.",
+      "correctiveAction": {
+        "type": "healthIndicator"
+      }
+    }
+  ],
+  "totalCriticalHealthIssues": 0,
+  "enrichedHealthIndicators": []
+}`
+
+var upgradeTests = []struct {
+	desc, status, esDeprecations string
+	ready, critical, warning     float64
+}{
+	{
+		desc:           "8.x status with es_deprecations",
+		status:         `{"readyForUpgrade":false,"details":"The following issues must be resolved before upgrading: 1 Elasticsearch deprecation issue."}`,
+		esDeprecations: upgradeTestESDeprecations,
+		ready:          0,
+		critical:       1,
+		warning:        2,
+	},
+	{
+		desc:           "8.0 to 8.12 es_deprecations",
+		status:         `{"readyForUpgrade":false,"details":"The following issues must be resolved before upgrading: 1 Elasticsearch deprecation issue."}`,
+		esDeprecations: `{"totalCriticalDeprecations":1,"deprecations":[{"type":"index_settings","level":"critical","resolveDuringUpgrade":false,"message":"Index created before 7.0","index":"logs-2019.01"},{"type":"cluster_settings","level":"warning","resolveDuringUpgrade":false,"message":"Transient cluster settings are deprecated"}]}`,
+		ready:          0,
+		critical:       1,
+		warning:        1,
+	},
+	{
+		desc:     "7.x status with embedded deprecations",
+		status:   `{"readyForUpgrade":true,"cluster":[{"level":"warning"}],"indices":[]}`,
+		ready:    1,
+		critical: 0,
+		warning:  1,
+	},
+}
+
+func TestUpgradeExporterCollect(t *testing.T) {
+	for _, ut := range upgradeTests {
+		t.Run(ut.desc, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case upgradeStatusPath:
+					fmt.Fprint(w, ut.status)
+				case upgradeESDeprecationsPath:
+					fmt.Fprint(w, ut.esDeprecations)
+				case kibanaDeprecationsPath:
+					fmt.Fprint(w, `{"deprecations":[{"level":"critical"}]}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer ts.Close()
+
+			collector, err := NewCollector(ts.URL, "", "", false)
+			if err != nil {
+				t.Fatalf("NewCollector failed with valid input")
+			}
+
+			e, err := NewUpgradeExporter("kibana", nil, collector)
+			if err != nil {
+				t.Fatalf("NewUpgradeExporter failed with valid input")
+			}
+
+			// elasticsearch critical/warning, kibana critical/warning
+			if c := testutil.CollectAndCount(testCollector{subCollector: e}, "kibana_upgrade_deprecations"); c != 4 {
+				t.Errorf("expected 4 deprecation series, got %d", c)
+			}
+
+			if v := testutil.ToFloat64(e.ready); v != ut.ready {
+				t.Errorf("expected ready %f, got %f", ut.ready, v)
+			}
+
+			if v := testutil.ToFloat64(e.deprecations.WithLabelValues("elasticsearch", "critical")); v != ut.critical {
+				t.Errorf("expected %f critical deprecations, got %f", ut.critical, v)
+			}
+
+			if v := testutil.ToFloat64(e.deprecations.WithLabelValues("elasticsearch", "warning")); v != ut.warning {
+				t.Errorf("expected %f warning deprecations, got %f", ut.warning, v)
+			}
+		})
+	}
+}
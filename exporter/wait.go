@@ -0,0 +1,96 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultWaitInitialInterval = time.Second
+	defaultWaitMaxInterval     = 30 * time.Second
+)
+
+// WaitOptions controls how often WaitForConnection checks Kibana, and for
+// how long.
+type WaitOptions struct {
+	// InitialInterval is the delay after the first failed check, doubled
+	// after each failure, defaults to 1s
+	InitialInterval time.Duration
+
+	// MaxInterval is the maximum delay between two checks, defaults to
+	// 30s
+	MaxInterval time.Duration
+
+	// Timeout is the maximum time to wait, 0 waits until the context is
+	// cancelled
+	Timeout time.Duration
+}
+
+// backoff returns the delay after the given number of failed checks,
+// doubling from the initial interval up to the maximum interval. Half of
+// the delay is random, so that exporters restarted together do not check
+// Kibana together.
+func backoff(failures int, initial, max time.Duration, rnd *rand.Rand) time.Duration {
+	delay := initial
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	return half + time.Duration(rnd.Int63n(int64(half)+1))
+}
+
+// WaitForConnection is a method to block until Kibana becomes available,
+// with exponential backoff between the checks. Returns an error if the
+// context is cancelled, or if Kibana is not available within the timeout.
+func (c *KibanaCollector) WaitForConnection(ctx context.Context, opts WaitOptions) error {
+	if opts.InitialInterval <= 0 {
+		opts.InitialInterval = defaultWaitInitialInterval
+	}
+
+	if opts.MaxInterval <= 0 {
+		opts.MaxInterval = defaultWaitMaxInterval
+	}
+
+	if opts.MaxInterval < opts.InitialInterval {
+		opts.MaxInterval = opts.InitialInterval
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	//#nosec G404 -- only used for jitter
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for failures := 1; ; failures++ {
+		if c.TestConnection(ctx) {
+			log.Info().
+				Msg("kibana is up")
+			return nil
+		}
+
+		delay := backoff(failures, opts.InitialInterval, opts.MaxInterval, rnd)
+		log.Info().
+			Msgf("waiting for kibana to be responsive, checking again in %s", delay.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			if opts.Timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("kibana was not responsive within %s", opts.Timeout)
+			}
+
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	bounds := []struct {
+		failures int
+		min, max time.Duration
+	}{
+		{failures: 1, min: 500 * time.Millisecond, max: time.Second},
+		{failures: 2, min: time.Second, max: 2 * time.Second},
+		{failures: 3, min: 2 * time.Second, max: 4 * time.Second},
+		{failures: 5, min: 5 * time.Second, max: 10 * time.Second},
+		{failures: 100, min: 5 * time.Second, max: 10 * time.Second},
+	}
+
+	for _, b := range bounds {
+		for i := 0; i < 100; i++ {
+			delay := backoff(b.failures, time.Second, 10*time.Second, rnd)
+			if delay < b.min || delay > b.max {
+				t.Fatalf("expected a delay between %s and %s after %d failures, got %s", b.min, b.max, b.failures, delay)
+			}
+		}
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"name":"kibana","status":{"overall":{"level":"available"}}}`)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	err = collector.WaitForConnection(context.Background(), WaitOptions{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error while waiting for a recovering Kibana: %s", err)
+	}
+
+	if requests.Load() != 3 {
+		t.Errorf("expected 3 checks, got %d", requests.Load())
+	}
+}
+
+func TestWaitForConnectionStopped(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	collector, err := NewCollector(ts.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input")
+	}
+
+	err = collector.WaitForConnection(context.Background(), WaitOptions{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+		Timeout:         50 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "not responsive within 50ms") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- collector.WaitForConnection(ctx, WaitOptions{})
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitForConnection did not return after the context was cancelled")
+	}
+}
@@ -1,38 +1,178 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/chamilad/kibana-prometheus-exporter/exporter"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 var (
-	addr           = flag.String("web.listen-address", ":9684", "The address to listen on for HTTP requests.")
-	metricsPath    = flag.String("web.telemetry-path", "/metrics", "The address to listen on for HTTP requests.")
+	addr        = flag.String("web.listen-address", ":9684", "The address to listen on for HTTP requests.")
+	metricsPath = flag.String("web.telemetry-path", "/metrics", "The address to listen on for HTTP requests.")
+	webConfig   = flag.String(
+		"web.config.file",
+		"",
+		"Path to a web configuration file to enable TLS and authentication for all the exporter endpoints, in the Prometheus exporter-toolkit format",
+	)
+	shutdownTimeout = flag.Duration(
+		"web.shutdown-timeout",
+		25*time.Second,
+		"Time to wait for in-flight requests to finish on shutdown, should be less than the termination grace period of the container",
+	)
+	disableMetrics = flag.Bool(
+		"web.disable-metrics",
+		false,
+		"Do not serve the Prometheus metrics endpoint, when the metrics are only sent with push, remote write or OTLP",
+	)
+	readyWindow = flag.Duration(
+		"web.ready-window",
+		5*time.Minute,
+		"The /ready endpoint reports ready if Kibana responded to a status request within this window",
+	)
+	oneshot = flag.Bool(
+		"oneshot",
+		false,
+		"Collect the metrics once, write them to stdout or -oneshot.output, and exit, with a non-zero exit code if Kibana did not respond, same as the scrape subcommand",
+	)
+	oneshotOutput = flag.String(
+		"oneshot.output",
+		"",
+		"File to write the -oneshot metrics to atomically, ex: a .prom file for the node_exporter textfile collector, stdout if empty",
+	)
+	configFile     = flag.String("config.file", "", "Path to the exporter configuration file, with the metrics, custom_metrics, probes, push, remote_write and otlp sections")
 	kibanaURI      = flag.String("kibana.uri", "", "The Kibana API to fetch metrics from")
 	kibanaUsername = flag.String("kibana.username", "", "The username to use for Kibana API")
 	kibanaPassword = flag.String("kibana.password", "", "The password to use for Kibana API")
 	kibanaSkipTLS  = flag.Bool("kibana.skip-tls", false, "Skip TLS verification for TLS secured Kibana URLs")
-	debug          = flag.Bool("debug", false, "Output verbose details during metrics collection, use for development only")
-	wait           = flag.Bool(
+	statusPoll     = flag.Duration(
+		"status.poll-interval",
+		0,
+		"Poll Kibana status in the background on this interval to track status changes between Prometheus scrapes, 0 disables polling",
+	)
+	legacyStatus = flag.Bool(
+		"metrics.legacy-status",
+		true,
+		"Also export status levels as the float encoded kibana_status, kibana_core_es_status, and kibana_core_savedobjects_status gauges",
+	)
+	naming = flag.String(
+		"metrics.naming",
+		exporter.NamingLegacy,
+		"Metric naming scheme, legacy, standard (Prometheus conventions with base units), or both",
+	)
+	namespace = flag.String(
+		"metrics.namespace",
+		"kibana",
+		"Prefix of all the exported metric names, overrides the namespace in the config file",
+	)
+	debug = flag.Bool("debug", false, "Output verbose details during metrics collection, use for development only")
+	// kept so that existing command lines do not fail to parse
+	_ = flag.Bool(
 		"wait",
 		false,
-		"Wait for Kibana to be responsive before starting, setting this to false would cause the exporter to error out instead of waiting",
+		"Deprecated and ignored, the exporter starts without Kibana and waits for it in the background, see -kibana.wait-timeout",
+	)
+	breakerFailures = flag.Int(
+		"kibana.breaker-failures",
+		3,
+		"Consecutive failed status requests that stop requesting Kibana for a while, serving the last good status, 0 disables the circuit breaker",
+	)
+	breakerMaxBackoff = flag.Duration(
+		"kibana.breaker-max-backoff",
+		5*time.Minute,
+		"Maximum time the circuit breaker stops requesting Kibana, including the Retry-After delays requested by Kibana",
+	)
+	requestTimeout = flag.Duration(
+		"kibana.timeout",
+		10*time.Second,
+		"Maximum time a request to Kibana can take, including reading the response, 0 disables the limit",
+	)
+	minInterval = flag.Duration(
+		"kibana.min-interval",
+		0,
+		"Minimum interval between the Kibana requests for scrapes, the scrapes within it are served the last collected metrics, 0 only shares the requests between concurrent scrapes",
+	)
+	reportingInterval = flag.Duration(
+		"reporting.interval",
+		time.Minute,
+		"Minimum interval between two walks of the Kibana reporting jobs list for the reporting collector, the scrapes within it are served the previous counts",
 	)
-	namespace = "kibana"
+	waitInterval = flag.Duration(
+		"kibana.wait-interval",
+		30*time.Second,
+		"Maximum interval between the checks while waiting for Kibana to be responsive at start up, the checks back off exponentially up to this",
+	)
+	waitTimeout = flag.Duration(
+		"kibana.wait-timeout",
+		0,
+		"Maximum time to wait for Kibana to be responsive at start up before exiting, 0 waits indefinitely",
+	)
+	constLabels    stringsFlag
+	includeMetrics stringsFlag
+	excludeMetrics stringsFlag
+	renameMetrics  stringsFlag
+	collectors     = exporter.NewCollectorFlags(flag.CommandLine)
 )
 
+// stringsFlag is a flag that can be repeated, collecting all the values.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func init() {
+	flag.Var(
+		&constLabels,
+		"metrics.const-label",
+		"Constant label to add to all the exported metrics in the key=value format, can be repeated, overrides the config file const labels",
+	)
+	flag.Var(
+		&includeMetrics,
+		"metrics.include",
+		"Regular expression of the metric names to export, can be repeated, added to the config file include list",
+	)
+	flag.Var(
+		&excludeMetrics,
+		"metrics.exclude",
+		"Regular expression of the metric names to drop, can be repeated, added to the config file exclude list",
+	)
+	flag.Var(
+		&renameMetrics,
+		"metrics.rename",
+		"Rename metrics in the regex=replacement format, can be repeated, applied after the config file relabel rules",
+	)
+}
+
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
 
-	flag.Parse()
+	// "kibana-exporter scrape [flags]" is the same as -oneshot
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "scrape" {
+		*oneshot = true
+		args = args[1:]
+	}
+
+	// exits on errors, as flag.Parse does
+	_ = flag.CommandLine.Parse(args)
 	*kibanaURI = strings.TrimSpace(*kibanaURI)
 	*kibanaUsername = strings.TrimSpace(*kibanaUsername)
 	*kibanaPassword = strings.TrimSpace(*kibanaPassword)
@@ -50,24 +190,278 @@ func main() {
 	*kibanaURI = strings.TrimSuffix(*kibanaURI, "/")
 	log.Printf("using Kibana URL: %s", *kibanaURI)
 
-	collector, err := exporter.NewCollector(*kibanaURI, *kibanaUsername, *kibanaPassword, *kibanaSkipTLS)
+	// fail early instead of when the server starts
+	if err := web.Validate(*webConfig); err != nil {
+		log.Fatal().Msgf("error while loading web config file: %s", err)
+	}
+
+	if *breakerFailures < 0 || *breakerMaxBackoff <= 0 {
+		log.Fatal().Msg("-kibana.breaker-failures cannot be negative, and -kibana.breaker-max-backoff should be positive")
+	}
+
+	if *requestTimeout < 0 {
+		log.Fatal().Msg("-kibana.timeout cannot be negative")
+	}
+
+	if *waitInterval <= 0 || *waitTimeout < 0 {
+		log.Fatal().Msg("-kibana.wait-interval should be positive, and -kibana.wait-timeout cannot be negative")
+	}
+
+	if *readyWindow <= 0 {
+		log.Fatal().Msg("-web.ready-window should be positive")
+	}
+
+	if *shutdownTimeout < 0 {
+		log.Fatal().Msg("-web.shutdown-timeout cannot be negative")
+	}
+
+	config := &exporter.Config{}
+	if *configFile != "" {
+		log.Info().Msgf("using config file: %s", *configFile)
+
+		var err error
+		config, err = exporter.LoadConfig(*configFile)
+		if err != nil {
+			log.Fatal().Msgf("error while loading config file: %s", err)
+		}
+	}
+
+	// the flag takes precedence over the config file only if it was set
+	namespaceSet := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "metrics.namespace":
+			namespaceSet = true
+		case "wait":
+			log.Warn().Msg("-wait is deprecated and ignored, the exporter always waits for Kibana in the background")
+		}
+	})
+
+	if !namespaceSet && config.Metrics.Namespace != "" {
+		*namespace = config.Metrics.Namespace
+	}
+
+	labels, err := exporter.ParseConstLabels(constLabels)
+	if err != nil {
+		log.Fatal().Msgf("error while parsing const labels: %s", err)
+	}
+
+	for name, value := range config.Metrics.ConstLabels {
+		if _, ok := labels[name]; !ok {
+			labels[name] = value
+		}
+	}
+
+	if err := exporter.ValidateConstLabels(labels); err != nil {
+		log.Fatal().Msgf("error while validating const labels: %s", err)
+	}
+
+	renames, err := exporter.ParseRenames(renameMetrics)
+	if err != nil {
+		log.Fatal().Msgf("error while parsing metric renames: %s", err)
+	}
+
+	// the include and exclude lists and the relabel rules apply to all the
+	// exported metrics, wherever they are sent
+	filtered := func(g prometheus.Gatherer) prometheus.Gatherer {
+		fg, err := exporter.NewFilteredGatherer(
+			g,
+			append(config.Metrics.Include, includeMetrics...),
+			append(config.Metrics.Exclude, excludeMetrics...),
+			append(config.Metrics.Relabel, renames...),
+		)
+		if err != nil {
+			log.Fatal().Msgf("error while parsing metric filters: %s", err)
+		}
+
+		return fg
+	}
+
+	// the oneshot output only has the Kibana metrics, without the Go
+	// runtime metrics of the exporter process
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	if *oneshot {
+		registry := prometheus.NewRegistry()
+		gatherer = registry
+		registerer = registry
+	}
+
+	gatherer = filtered(gatherer)
+
+	collector, err := exporter.NewCollector(
+		*kibanaURI,
+		*kibanaUsername,
+		*kibanaPassword,
+		*kibanaSkipTLS,
+		exporter.WithCircuitBreaker(*breakerFailures, *breakerMaxBackoff),
+		exporter.WithRequestTimeout(*requestTimeout),
+	)
 	if err != nil {
 		log.Fatal().Msgf("error while initializing collector: %s", err)
 	}
 
-	exporter, err := exporter.NewExporter(namespace, collector)
+	// cancelled on SIGINT or SIGTERM, to stop the background goroutines
+	// and the startup wait
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// cancelled once the server has shut down, so that the scrapes in
+	// flight when the signal is received still get the Kibana metrics
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	if *oneshot {
+		// there is no server to drain, the signal stops the collection
+		requestCtx = ctx
+	}
+
+	kibanaExporter, err := exporter.NewExporter(
+		*namespace,
+		collector,
+		exporter.WithContext(requestCtx),
+		exporter.WithConstLabels(labels),
+		exporter.WithLegacyStatus(*legacyStatus),
+		exporter.WithNaming(*naming),
+		exporter.WithCollectors(collectors.Enabled()),
+		exporter.WithCustomMetrics(config.CustomMetrics),
+		exporter.WithMinInterval(*minInterval),
+		exporter.WithReportingInterval(*reportingInterval),
+	)
 	if err != nil {
 		log.Fatal().Msgf("error while initializing exporter: %s", err)
 	}
 
-	if *wait {
-		// blocking wait for Kibana to be responsive
-		collector.WaitForConnection()
-	} else if !collector.TestConnection() {
-		log.Fatal().Msg("not waiting for Kibana to be responsive")
+	registerer.MustRegister(kibanaExporter)
+
+	if *oneshot {
+		// a single attempt, without waiting for Kibana or starting the
+		// server and the background tasks
+		if err := runOneshot(gatherer, kibanaExporter, *oneshotOutput); err != nil {
+			log.Fatal().Msgf("error while collecting metrics once: %s", err)
+		}
+
+		return
+	}
+
+	var prober *exporter.Prober
+	if len(config.Probes.Endpoints) > 0 {
+		prober, err = exporter.NewProber(*namespace, labels, collector, config.Probes)
+		if err != nil {
+			log.Fatal().Msgf("error while initializing synthetic probes: %s", err)
+		}
+
+		registerer.MustRegister(prober)
+	}
+
+	var pusher *exporter.Pusher
+	if config.Push.URL != "" {
+		// only the Kibana metrics are pushed, without the Go runtime
+		// metrics of the exporter process
+		pushRegistry := prometheus.NewRegistry()
+		pushRegistry.MustRegister(kibanaExporter)
+
+		pusher, err = exporter.NewPusher(filtered(pushRegistry), config.Push)
+		if err != nil {
+			log.Fatal().Msgf("error while initializing metrics push: %s", err)
+		}
+	}
+
+	var remoteWriter *exporter.RemoteWriter
+	if config.RemoteWrite.URL != "" {
+		// as for the push, without the Go runtime metrics, but with the
+		// remote write metrics so that failures are visible remotely
+		remoteWriteRegistry := prometheus.NewRegistry()
+		remoteWriteRegistry.MustRegister(kibanaExporter)
+
+		remoteWriter, err = exporter.NewRemoteWriter(*namespace, labels, filtered(remoteWriteRegistry), config.RemoteWrite)
+		if err != nil {
+			log.Fatal().Msgf("error while initializing remote write: %s", err)
+		}
+
+		remoteWriteRegistry.MustRegister(remoteWriter)
+		registerer.MustRegister(remoteWriter)
+	}
+
+	var otlpExporter *exporter.OTLPExporter
+	if config.OTLP.Endpoint != "" {
+		// as for the push, the constant labels are added as data point
+		// attributes
+		otlpRegistry := prometheus.NewRegistry()
+		otlpRegistry.MustRegister(kibanaExporter)
+
+		otlpExporter, err = exporter.NewOTLPExporter(filtered(otlpRegistry), collector, config.OTLP)
+		if err != nil {
+			log.Fatal().Msgf("error while initializing OTLP export: %s", err)
+		}
+	}
+
+	if *disableMetrics && pusher == nil && remoteWriter == nil && otlpExporter == nil {
+		log.Warn().
+			Msg("the metrics endpoint is disabled without push, remote write or OTLP, the metrics are not sent anywhere")
+	}
+
+	// the server starts without waiting for Kibana, kibana_up is 0 until
+	// Kibana responds, and the status polling starts once it does
+	var background sync.WaitGroup
+
+	// probed without waiting, so that the probe failures are visible
+	// while Kibana does not respond
+	if prober != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			prober.Run(ctx)
+		}()
+	}
+
+	// pushed, written and exported without waiting, so that kibana_up 0
+	// is sent while Kibana does not respond
+	if pusher != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			pusher.Run(ctx)
+		}()
+	}
+
+	if remoteWriter != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			remoteWriter.Run(ctx)
+		}()
 	}
 
-	prometheus.MustRegister(exporter)
+	if otlpExporter != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			otlpExporter.Run(ctx)
+		}()
+	}
+
+	waitErr := make(chan error, 1)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+
+		err := collector.WaitForConnection(ctx, exporter.WaitOptions{
+			MaxInterval: *waitInterval,
+			Timeout:     *waitTimeout,
+		})
+		if err != nil {
+			waitErr <- err
+			return
+		}
+
+		if *statusPoll > 0 {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				kibanaExporter.PollStatus(ctx, *statusPoll)
+			}()
+		}
+	}()
 
 	// readable output
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -92,7 +486,15 @@ func main() {
 		}
 	})
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	// unlike /healthz, this checks whether Kibana can be reached
+	http.Handle("/ready", exporter.ReadyHandler(collector, *readyWindow))
+
+	if !*disableMetrics {
+		http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}),
+		))
+	}
 
 	log.Info().Msgf("starting metrics server at %s", *addr)
 	// CWE-676, https://app.deepsource.com/directory/analyzers/go/issues/GO-S2114
@@ -101,6 +503,66 @@ func main() {
 		ReadHeaderTimeout: 3 * time.Second, // low timeout since the response is straightforward
 	}
 
-	err = server.ListenAndServe()
-	log.Fatal().Msgf("%s", err)
+	listenAddresses := []string{*addr}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- web.ListenAndServe(server, &web.FlagConfig{
+			WebListenAddresses: &listenAddresses,
+			WebConfigFile:      webConfig,
+		}, toolkitLogger{})
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Fatal().Msgf("%s", err)
+	case err := <-waitErr:
+		if ctx.Err() == nil {
+			log.Fatal().Msgf("error while waiting for Kibana: %s", err)
+		}
+
+		// the wait was stopped by the shutdown signal
+		<-ctx.Done()
+	case <-ctx.Done():
+	}
+
+	// a second signal kills the process without waiting
+	stop()
+	log.Info().
+		Msgf("shutdown signal received, waiting up to %s for in-flight requests", *shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	shutdownServer(shutdownCtx, server, cancelRequests)
+
+	// the background goroutines return once the context is cancelled, or
+	// when their in-flight requests finish
+	stopped := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Warn().
+			Msg("background tasks did not stop within the shutdown timeout")
+	}
+
+	log.Info().
+		Msg("kibana exporter stopped")
+}
+
+// shutdownServer will wait for the in-flight requests to be answered, up
+// to the deadline of the context, before cancelling the Kibana requests,
+// so that the last scrapes are not answered with kibana_up 0.
+func shutdownServer(ctx context.Context, server *http.Server, cancelRequests context.CancelFunc) {
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warn().
+			Msgf("error while shutting down metrics server: %s", err)
+	}
+
+	// the scrapes still in flight after the shutdown timeout stop here
+	cancelRequests()
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chamilad/kibana-prometheus-exporter/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestShutdownServerAnswersInFlightScrapes(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	kibana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+
+		// a slow Kibana, still responding when the signal is received
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, `{"name":"kibana","version":{"number":"8.7.1"},"status":{"overall":{"level":"available"}}}`)
+	}))
+	defer kibana.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	collector, err := exporter.NewCollector(kibana.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input: %s", err)
+	}
+
+	kibanaExporter, err := exporter.NewExporter("kibana", collector, exporter.WithContext(requestCtx))
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(kibanaExporter)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+
+	server := &http.Server{
+		Handler:           promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	go func() {
+		_ = server.Serve(l)
+	}()
+
+	scraped := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+		if err != nil {
+			scraped <- err.Error()
+			return
+		}
+
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		scraped <- string(body)
+	}()
+
+	<-requested
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("could not find the test process: %s", err)
+	}
+
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("could not send the signal: %s", err)
+	}
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shutdownServer(shutdownCtx, server, cancelRequests)
+
+	select {
+	case body := <-scraped:
+		if !strings.Contains(body, "\nkibana_up 1\n") {
+			t.Errorf("expected the in-flight scrape to get the Kibana metrics, got:\n%s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the in-flight scrape was not answered")
+	}
+}
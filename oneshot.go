@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/chamilad/kibana-prometheus-exporter/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+)
+
+// runOneshot will collect the metrics once, and write them in the
+// Prometheus text format to the output file, or to stdout if the output is
+// empty or "-". The metrics are written even if Kibana did not respond, so
+// that kibana_up is reported, but an error is returned.
+func runOneshot(gatherer prometheus.Gatherer, kibanaExporter *exporter.Exporter, output string) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		// partial results are still written, as promhttp does
+		log.Warn().
+			Msgf("error while gathering metrics: %s", err)
+	}
+
+	var buf bytes.Buffer
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			return fmt.Errorf("error while encoding metrics: %s", err)
+		}
+	}
+
+	if output == "" || output == "-" {
+		if _, err := io.Copy(os.Stdout, &buf); err != nil {
+			return fmt.Errorf("error while writing metrics to stdout: %s", err)
+		}
+	} else {
+		if err := writeFileAtomic(output, buf.Bytes()); err != nil {
+			return err
+		}
+
+		log.Info().
+			Msgf("wrote %d metric families to %s", len(families), output)
+	}
+
+	if !kibanaExporter.KibanaUp() {
+		return errors.New("kibana did not respond to the status request")
+	}
+
+	return nil
+}
+
+// writeFileAtomic will write the content to a temporary file in the same
+// directory and rename it to the path, so that readers like the
+// node_exporter textfile collector never see a partial file.
+func writeFileAtomic(path string, content []byte) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	// the textfile collector ignores files without the .prom extension
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp")
+	if err != nil {
+		return fmt.Errorf("error while creating temporary file for %s: %s", path, err)
+	}
+
+	// removing fails once renamed, which is expected
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error while writing metrics to %s: %s", tmp.Name(), err)
+	}
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error while setting permissions of %s: %s", tmp.Name(), err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error while syncing %s: %s", tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error while closing %s: %s", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error while renaming %s to %s: %s", tmp.Name(), path, err)
+	}
+
+	return nil
+}
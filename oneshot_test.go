@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chamilad/kibana-prometheus-exporter/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// closedKibanaURL returns the URL of a server that is no longer listening
+func closedKibanaURL() string {
+	kibana := httptest.NewServer(nil)
+	kibana.Close()
+
+	return kibana.URL
+}
+
+// dirEntries returns the names of the files in the directory
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("could not read %s: %s", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestWriteFileAtomicReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kibana.prom")
+
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatalf("could not write %s: %s", path, err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new\n")); err != nil {
+		t.Fatalf("writeFileAtomic failed with valid input: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read %s: %s", path, err)
+	}
+
+	if string(content) != "new\n" {
+		t.Errorf("expected the file to be replaced, got %q", content)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("could not stat %s: %s", path, err)
+	}
+
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("expected the file to be readable by the textfile collector, got %s", info.Mode().Perm())
+	}
+
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("expected only the output file to be left, got %v", names)
+	}
+}
+
+func TestWriteFileAtomicErrorRemovesTempFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// renaming over a non-empty directory fails after the temporary file
+	// is written
+	path := filepath.Join(dir, "kibana.prom")
+	if err := os.MkdirAll(filepath.Join(path, "keep"), 0o755); err != nil {
+		t.Fatalf("could not create %s: %s", path, err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new\n")); err == nil {
+		t.Fatalf("writeFileAtomic did not fail when the path is a directory")
+	}
+
+	if names := dirEntries(t, dir); len(names) != 1 || names[0] != "kibana.prom" {
+		t.Errorf("expected no temporary file to be left, got %v", names)
+	}
+}
+
+func TestWriteFileAtomicMissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "kibana.prom")
+
+	if err := writeFileAtomic(path, []byte("new\n")); err == nil {
+		t.Errorf("writeFileAtomic did not fail when the directory does not exist")
+	}
+}
+
+func TestRunOneshotKibanaDown(t *testing.T) {
+	collector, err := exporter.NewCollector(closedKibanaURL(), "", "", false)
+	if err != nil {
+		t.Fatalf("NewCollector failed with valid input: %s", err)
+	}
+
+	kibanaExporter, err := exporter.NewExporter("kibana", collector)
+	if err != nil {
+		t.Fatalf("NewExporter failed with valid input: %s", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(kibanaExporter)
+
+	output := filepath.Join(t.TempDir(), "kibana.prom")
+	if err := runOneshot(registry, kibanaExporter, output); err == nil {
+		t.Errorf("runOneshot did not fail when Kibana is down")
+	}
+
+	// kibana_up is still written for the textfile collector
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("could not read %s: %s", output, err)
+	}
+
+	if !strings.Contains(string(content), "\nkibana_up 0\n") {
+		t.Errorf("expected kibana_up 0 to be written, got:\n%s", content)
+	}
+}
+
+func TestScrapeExitStatusKibanaDown(t *testing.T) {
+	if os.Getenv("KIBANA_EXPORTER_TEST_MAIN") == "1" {
+		os.Args = []string{"kibana-exporter", "scrape", "-kibana.uri", os.Getenv("KIBANA_EXPORTER_TEST_URI")}
+		main()
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestScrapeExitStatusKibanaDown$")
+	cmd.Env = append(os.Environ(), "KIBANA_EXPORTER_TEST_MAIN=1", "KIBANA_EXPORTER_TEST_URI="+closedKibanaURL())
+
+	out, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() == 0 {
+		t.Fatalf("expected a non-zero exit status when Kibana is down, got %v:\n%s", err, out)
+	}
+
+	if !strings.Contains(string(out), "kibana_up 0") {
+		t.Errorf("expected kibana_up 0 on stdout, got:\n%s", out)
+	}
+}
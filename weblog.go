@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// toolkitLogger adapts zerolog to the go-kit logger interface used by the
+// exporter-toolkit web server, which logs key value pairs with the level
+// and the message as keys.
+type toolkitLogger struct{}
+
+func (toolkitLogger) Log(keyvals ...interface{}) error {
+	level := zerolog.InfoLevel
+	msg := ""
+	fields := map[string]interface{}{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		switch key {
+		case "level":
+			if l, err := zerolog.ParseLevel(fmt.Sprint(keyvals[i+1])); err == nil {
+				level = l
+			}
+		case "msg":
+			msg = fmt.Sprint(keyvals[i+1])
+		default:
+			fields[key] = keyvals[i+1]
+		}
+	}
+
+	log.WithLevel(level).
+		Fields(fields).
+		Msg(msg)
+
+	return nil
+}